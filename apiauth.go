@@ -1,16 +1,26 @@
+// Package apiauth signs and verifies HTTP requests as the Ruby api_auth
+// gem does.
+//
+// The package holds no mutable state of its own: its functions, and
+// Signers and Verifiers that are not modified once in use, are safe for
+// concurrent use. The exported package-level variables, such as
+// DefaultCredentialOptions, should only be changed during
+// initialization, before any goroutine reads them.
 package apiauth
 
 import (
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
-	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"strings"
+	"net/url"
 	"time"
 )
 
+// gmt is the location Dates are formatted in. It is set once by init and
+// only read afterwards.
 var gmt *time.Location
 
 func init() {
@@ -25,92 +35,353 @@ func init() {
 // adds the resulting Authorization header value to it. If any
 // of the prerequisite headers are absent, an error is returned.
 func Sign(r *http.Request, accessID, secret string) error {
-	if err := sufficientHeaders(r); err != nil {
+	s := Signer{AccessID: accessID, Secret: secret}
+	return s.Sign(r)
+}
+
+// SignWithMethod computs the signature of the given HTTP request
+// as in Sign except that the canonical string includes the HTTP
+// request method.
+func SignWithMethod(r *http.Request, accessID, secret string) error {
+	s := Signer{AccessID: accessID, Secret: secret, WithMethod: true}
+	return s.Sign(r)
+}
+
+// SignHeader computes the signature for the given HTTP request as in
+// Sign, without the request method, and returns the resulting
+// `APIAuth access_id:signature` value without adding it to the request,
+// for callers that send it by other means. If any of the prerequisite
+// headers are absent, an error is returned.
+func SignHeader(r *http.Request, accessID, secret string) (string, error) {
+	s := Signer{AccessID: accessID, Secret: secret}
+	return s.SignHeader(r)
+}
+
+// Do signs a request as in SignWithMethod and sends it with client, or
+// with http.DefaultClient if client is nil. The Date header is set to the
+// current time and, if the request has a body, its Content-MD5 is
+// computed, unless they are already present. The Content-Type must be
+// set by the caller. If signing fails, the error is returned without
+// sending the request.
+func Do(client *http.Client, r *http.Request, accessID, secret string) (*http.Response, error) {
+	s := Signer{AccessID: accessID, Secret: secret, WithMethod: true}
+	return s.Do(client, r)
+}
+
+// SignClone signs a deep copy of a request, leaving the request itself
+// untouched, and returns the copy; see Signer.SignClone.
+func SignClone(r *http.Request, accessID, secret string) (*http.Request, error) {
+	s := Signer{AccessID: accessID, Secret: secret}
+	return s.SignClone(r)
+}
+
+// Verify checks a request for validity: all required headers
+// are present and the signature matches.
+func Verify(r *http.Request, secret string) error {
+	v := Verifier{Secret: secret}
+	return v.Verify(r)
+}
+
+// VerifyHTTP checks a request as in Verify, also returning the HTTP
+// status code to respond with, as StatusCode gives it: http.StatusOK if
+// the request verifies, 401 if its Authorization is missing or does not
+// match, 403 if it is not permitted, and 400 if it is malformed.
+func VerifyHTTP(r *http.Request, secret string) (statusCode int, err error) {
+	err = Verify(r, secret)
+	return StatusCode(err), err
+}
+
+// VerifyAndIdentify checks a request as in Verify, and also returns the
+// access ID it was signed under and the time in its Date header; see
+// Verifier.VerifyAndIdentify.
+func VerifyAndIdentify(r *http.Request, secret string) (accessID string, signed time.Time, err error) {
+	v := Verifier{Secret: secret}
+	return v.VerifyAndIdentify(r)
+}
+
+// VerifyWithBodyReader checks a request as in Verify, after first reading
+// and buffering its body, which may be chunked, and checking it against
+// the Content-MD5 header. The body is restored for downstream handlers.
+// At most DefaultMaxBodySize bytes are read.
+func VerifyWithBodyReader(r *http.Request, secret string) error {
+	v := Verifier{Secret: secret}
+	return v.VerifyWithBodyReader(r)
+}
+
+// VerifySafe checks a request as in Verify, but requires the request
+// method to be included in the signature of POST, PUT, PATCH and DELETE
+// requests, which could otherwise be replayed under another method,
+// while still accepting the deprecated signature without it for other
+// methods, such as GET and HEAD; see Verifier.RequireMethodForMutating.
+func VerifySafe(r *http.Request, secret string) error {
+	v := Verifier{Secret: secret, RequireMethodForMutating: true}
+	return v.Verify(r)
+}
+
+// VerifyAccessID checks a request as in Verify, and also requires it to
+// have been signed under expectedID, comparing the access IDs in constant
+// time. A correctly signed request with another access ID fails with
+// ErrAccessIDMismatch.
+func VerifyAccessID(r *http.Request, expectedID, secret string) error {
+	v := Verifier{Secret: secret, AccessID: expectedID}
+	return v.Verify(r)
+}
+
+// SignWithAAD signs a request as Sign does, with the additional
+// authenticated data aad appended to the canonical string; see
+// Canonicalizer.AAD. The request only verifies with VerifyWithAAD given
+// the same aad. An empty aad signs as Sign does.
+func SignWithAAD(r *http.Request, accessID, secret string, aad []byte) error {
+	s := Signer{AccessID: accessID, Secret: secret}
+	s.AAD = aad
+	return s.Sign(r)
+}
+
+// VerifyWithAAD checks a request signed with SignWithAAD, as Verify
+// does, given the additional authenticated data the client signed it
+// with, such as a session ID the caller has already authenticated.
+// Requests signed with any other aad fail with ErrSignatureMismatch.
+func VerifyWithAAD(r *http.Request, secret string, aad []byte) error {
+	v := Verifier{Secret: secret}
+	v.AAD = aad
+	return v.Verify(r)
+}
+
+// VerifyAll checks a request carrying several Authorization headers, as
+// when each proxy in a chain adds its own signature, returning nil only if
+// every one verifies as in Verify with the secret keyFunc returns for its
+// access ID. Each signature must be in its own header field. Errors from
+// keyFunc are returned as they are.
+func VerifyAll(r *http.Request, keyFunc func(id string) (string, error)) error {
+	v := Verifier{KeyFunc: keyFunc}
+	return v.VerifyAll(r)
+}
+
+// VerifyDescription builds a request from a method, URL and headers, as
+// copied from a log or a curl command line, and verifies it as in Verify.
+// The request has no body, so Content-Type and Content-MD5 are signed as
+// given but not required.
+func VerifyDescription(method, rawURL string, headers map[string]string, secret string) error {
+	r, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
 		return err
 	}
 
-	preexisting := r.Header.Get("Authorization")
-	if preexisting != "" {
-		return fmt.Errorf("Authorization header already present")
+	for name, value := range headers {
+		r.Header.Set(name, value)
 	}
 
-	sig := Compute(CanonicalString(r), secret)
-	r.Header.Set("Authorization", fmt.Sprintf("APIAuth %s:%s", accessID, sig))
-
-	return nil
+	return Verify(r, secret)
 }
 
-// SignWithMethod computs the signature of the given HTTP request
-// as in Sign except that the canonical string includes the HTTP
-// request method.
-func SignWithMethod(r *http.Request, accessID, secret string) error {
-	if err := sufficientHeaders(r); err != nil {
+// VerifyRaw checks a request given as its method, request target and
+// headers, as a framework exposing headers as a plain map provides them,
+// and verifies it as in Verify. The uri is parsed as RequestFromLog
+// parses it, and the Host header, if any, is used as the host. The
+// request has no body, so Content-Type and Content-MD5 are signed as
+// given but not required. headers is not modified.
+func VerifyRaw(method, uri string, headers http.Header, secret string) error {
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
 		return err
 	}
 
-	preexisting := r.Header.Get("Authorization")
-	if preexisting != "" {
-		return fmt.Errorf("Authorization header already present")
+	r := &http.Request{
+		Method:     method,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     headers,
+		Host:       u.Host,
+	}
+	if host := headers.Get("Host"); host != "" {
+		r.Host = host
 	}
 
-	sig := Compute(CanonicalStringWithMethod(r), secret)
-	r.Header.Set("Authorization", fmt.Sprintf("APIAuth %s:%s", accessID, sig))
+	return Verify(r, secret)
+}
 
-	return nil
+// RequestFromLog builds a request from the fields of an access log line,
+// for verifying its signature offline with Verify. The uri is the
+// request target exactly as logged, such as `/some/path?x=1`, and is
+// parsed as a server parses it, so that escaping in the path and query
+// is reproduced in the canonical string. Empty headers are omitted. The
+// request has no body, so the logged Content-MD5 is signed but not
+// checked. A uri that cannot be parsed, which a server would have
+// rejected, is used as the path.
+func RequestFromLog(method, uri, contentType, contentMD5, date, authorization string) *http.Request {
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		u = &url.URL{Path: uri}
+	}
+
+	r := &http.Request{
+		Method:     method,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+
+	for name, value := range map[string]string{
+		"Content-Type":  contentType,
+		"Content-MD5":   contentMD5,
+		"Date":          date,
+		"Authorization": authorization,
+	} {
+		if value != "" {
+			r.Header.Set(name, value)
+		}
+	}
+
+	return r
 }
 
-// Verify checks a request for validity: all required headers
-// are present and the signature matches.
-func Verify(r *http.Request, secret string) error {
+// UpgradeSignature verifies a request signed with the method-less
+// CanonicalString, and replaces its Authorization header with one
+// signed using CanonicalStringWithMethod under the same access ID.
+// If the legacy signature does not verify, the request is left
+// untouched and an error is returned.
+func UpgradeSignature(r *http.Request, secret string) error {
 	if err := sufficientHeaders(r); err != nil {
 		return err
 	}
 
 	auth := r.Header.Get("Authorization")
 	if auth == "" {
-		return fmt.Errorf("Authorization header not set")
+		return ErrMissingAuthorization
+	}
+
+	id, sig, err := Parse(auth)
+	if err != nil {
+		return err
+	}
+
+	if !VerifySignature(sig, CanonicalString(r), secret) {
+		return ErrSignatureMismatch
 	}
 
-	_, sig, err := Parse(auth)
+	r.Header.Del("Authorization")
+	return SignWithMethod(r, id, secret)
+}
+
+// VerifyConstantTime checks a request as in Verify, but does not return
+// early when a check fails: every header check is made and both candidate
+// signatures are always computed, so the time taken to reject a request
+// does not reveal which check failed. The first failure is returned.
+func VerifyConstantTime(r *http.Request, secret string) error {
+	if err := checkRequest(r); err != nil {
+		return err
+	}
+
+	// Every header is checked, not just those up to the first missing.
+	var err error
+	if missing := MissingHeaders(r); len(missing) > 0 {
+		err = missingHeader(missing[0])
+	}
+
+	auth := r.Header.Get("Authorization")
+	if auth == "" && err == nil {
+		err = ErrMissingAuthorization
+	}
+
+	_, sig, parseErr := Parse(auth)
+	if parseErr != nil && err == nil {
+		err = parseErr
+	}
+
+	legacy := VerifySignature(sig, CanonicalString(r), secret)
+	withMethod := VerifySignature(sig, CanonicalStringWithMethod(r), secret)
+
 	if err != nil {
 		return err
 	}
 
-	if VerifySignature(sig, CanonicalString(r), secret) || VerifySignature(sig, CanonicalStringWithMethod(r), secret) {
-		return nil
+	if !legacy && !withMethod {
+		return ErrSignatureMismatch
 	}
 
-	return fmt.Errorf("Signature mismatch")
+	return nil
 }
 
 // VerifySignature computes the expected signature for a given
 // canonical string and secret key pair, and returns true if the
-// given signature matches.
+// given signature matches. The signature is decoded once and the
+// raw MACs are compared in constant time.
 func VerifySignature(sig, canonicalString, secret string) bool {
-	expected := Compute(canonicalString, secret)
-	return expected == sig
+	return verifyMAC(sig, canonicalString, secretMAC(secret), Base64)
 }
 
 // Parse returns the access ID and signature present in the
 // given string, presumably taken from a request's Authorization
 // header. If the header does not match the expected `APIAuth access_id:signature`
-// format, an error is returned.
+// format, an error is returned. The access ID is everything before the first
+// colon, and the signature everything after it.
 func Parse(header string) (id, sig string, err error) {
-	var tokens []string
+	s, err := ParseSignature(header)
+	if err != nil || s.IsV2() {
+		return "", "", ErrMalformedHeader
+	}
+	return s.AccessID, s.Signature, nil
+}
 
-	if !strings.HasPrefix(header, "APIAuth ") {
-		goto malformed
+// AccessID returns the access ID claimed by a request's Authorization
+// header, in either the original or the V2 layout, for looking up its
+// secret before verifying it.
+//
+// The access ID is NOT verified, and is chosen by whoever sent the
+// request: do not trust it, log it as authenticated, or use it for
+// anything but the lookup until the request has been verified. See
+// Verifier.ClaimedAccessID for one checked as ValidateAccessID sets.
+func AccessID(r *http.Request) (string, error) {
+	if err := checkRequest(r); err != nil {
+		return "", err
 	}
 
-	tokens = strings.Split(header[8:], ":")
-	if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
-		goto malformed
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", ErrMissingAuthorization
 	}
 
-	return tokens[0], tokens[1], nil
+	sig, err := ParseSignature(header)
+	if err != nil {
+		return "", err
+	}
+	return sig.AccessID, nil
+}
+
+// ValidateHeader checks the structure of an Authorization header without
+// verifying it: it must parse as in ParseSignature, in either the
+// original or the V2 layout, and its signature must be base64 encoding a
+// MAC of the length produced by the algorithm the header declares:
+// HMAC-SHA1 for the `APIAuth` scheme, or that of an `APIAuth-<alg>`
+// scheme. It is a cheap pre-filter for requests that could never verify,
+// and returns ErrMalformedHeader, or ErrUnsupportedAlgorithm for a V2
+// header naming an algorithm that is not supported.
+func ValidateHeader(header string) error {
+	sig, err := ParseSignature(header)
+	if err != nil {
+		return err
+	}
+
+	size := sha1.Size
+	if sig.IsV2() {
+		newMAC, ok := sig.Algorithm.newMAC(nil)
+		if !ok {
+			return ErrUnsupportedAlgorithm
+		}
+		size = newMAC().Size()
+	}
+
+	mac, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil || len(mac) != size {
+		return ErrMalformedHeader
+	}
 
-malformed:
-	return "", "", fmt.Errorf("Malformed header: %s", header)
+	return nil
 }
 
 // Date returns a suitable value for a request's Date header,
@@ -124,67 +395,121 @@ func Date() string {
 // `Time#httpdate` spits out GMT, and I need to maintain
 // fairly rigid compatibility.
 func DateForTime(t time.Time) string {
-	return t.In(gmt).Format(time.RFC1123)
+	return DateForTimeLayout(t, time.RFC1123)
 }
 
-// CanonicalString returns the canonical string used for the signature
-// based on the headers in the given request.
-func CanonicalString(r *http.Request) string {
-	uri := r.URL.EscapedPath()
-	if uri == "" {
-		uri = "/"
+// DateForTimeLayout converts the given time to GMT, and returns it
+// formatted with the given layout, for peers that expect something
+// other than RFC1123 (e.g. time.RFC1123Z).
+func DateForTimeLayout(t time.Time, layout string) string {
+	return t.In(gmt).Format(layout)
+}
+
+// ParseDate parses the value of a Date header, accepting RFC1123 as
+// produced by Date along with the other formats permitted by HTTP/1.1
+// and time.RFC1123Z. Fractional seconds, as in `19:37:40.123`, are
+// accepted in any of them.
+func ParseDate(date string) (time.Time, error) {
+	t, err := http.ParseTime(date)
+	if err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC1123Z, date)
+}
+
+// ServerClockOffset returns how far the clock of the server that sent
+// the response is ahead of the local clock, based on its Date header.
+// The offset is negative when the server is behind, and is accurate to
+// about a second. Clients can add it to the time they sign with (see
+// Signer.ClockOffset) to avoid being rejected for skew. A nil response,
+// like one without a Date header, fails with ErrMissingDate.
+func ServerClockOffset(resp *http.Response) (time.Duration, error) {
+	if resp == nil {
+		return 0, ErrMissingDate
+	}
+
+	date := resp.Header.Get("Date")
+	if date == "" {
+		return 0, ErrMissingDate
 	}
 
-	if r.URL.RawQuery != "" {
-		uri = uri + "?" + r.URL.RawQuery
+	t, err := ParseDate(date)
+	if err != nil {
+		return 0, ErrInvalidDate
 	}
 
-	header := r.Header
+	return t.Sub(time.Now()), nil
+}
+
+// CanonicalString returns the canonical string used for the signature
+// based on the headers in the given request.
+func CanonicalString(r *http.Request) string {
+	return Canonicalizer{}.CanonicalString(r)
+}
 
-	return strings.Join([]string{
-		header.Get("Content-Type"),
-		header.Get("Content-MD5"),
-		uri,
-		header.Get("Date"),
-	}, ",")
+// CanonicalStringFullURL returns a canonical string as in CanonicalString
+// but with the full URL, including the scheme and host, in place of the
+// path and query; see Canonicalizer.FullURL.
+func CanonicalStringFullURL(r *http.Request) string {
+	return Canonicalizer{FullURL: true}.CanonicalString(r)
 }
 
 // CanonicalStringWithMethod returns a canonical string as in CanonicalString
 // but also includes the request method
 func CanonicalStringWithMethod(r *http.Request) string {
-	return strings.Join([]string{
-		strings.ToUpper(r.Method),
-		CanonicalString(r),
-	}, ",")
+	return Canonicalizer{}.CanonicalStringWithMethod(r)
+}
+
+// CanonicalComponents returns the components of the canonical string
+// CanonicalStringWithMethod returns, for tools that show what is signed.
+func CanonicalComponents(r *http.Request) []CanonicalComponent {
+	return Canonicalizer{}.CanonicalComponentsWithMethod(r)
 }
 
 // Compute computes the signature for a given canonical string, using
 // the HMAC-SHA1.
 func Compute(canonicalString, secret string) string {
-	mac := hmac.New(sha1.New, []byte(secret))
-	mac.Write([]byte(canonicalString))
-	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return base64.StdEncoding.EncodeToString(ComputeRaw(canonicalString, secret))
 }
 
-func sufficientHeaders(r *http.Request) error {
-	date := r.Header.Get("Date")
-	if date == "" {
-		return fmt.Errorf("No Date header present")
+// ComputeFromReader computes the signature for a canonical string read
+// from canonical, as Compute does, streaming it into the HMAC rather than
+// holding it in memory. Errors from reading canonical are returned as
+// they are.
+func ComputeFromReader(canonical io.Reader, secret string) (string, error) {
+	mac := hmac.New(sha1.New, []byte(secret))
+	if _, err := io.Copy(mac, canonical); err != nil {
+		return "", err
 	}
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
 
-	if r.Body == nil || r.Body == http.NoBody {
-		return nil
-	}
+// ComputeRaw computes the raw HMAC-SHA1 of a given canonical string,
+// without the base64 encoding applied by Compute.
+func ComputeRaw(canonicalString, secret string) []byte {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(canonicalString))
+	return mac.Sum(nil)
+}
 
-	contentType := r.Header.Get("Content-Type")
-	if contentType == "" {
-		return fmt.Errorf("No Content-Type header present")
-	}
+// MissingHeaders returns the names of all the headers Sign and Verify
+// require but that the request lacks, in the order in which they are
+// checked: Date, then, for requests with a body, Content-Type and
+// Content-MD5. Sign and Verify fail with the error for the first of them.
+func MissingHeaders(r *http.Request) []string {
+	return Canonicalizer{}.MissingHeaders(r)
+}
 
-	contentMD5 := r.Header.Get("Content-MD5")
-	if contentMD5 == "" {
-		return fmt.Errorf("No Content-MD5 header present")
+// authorizationHeader returns the name of the header carrying the
+// signature, defaulting to Authorization.
+func authorizationHeader(name string) string {
+	if name == "" {
+		return "Authorization"
 	}
+	return name
+}
 
-	return nil
+func sufficientHeaders(r *http.Request) error {
+	return Canonicalizer{}.sufficientHeaders(r)
 }
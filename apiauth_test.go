@@ -4,7 +4,14 @@ import (
 	"bytes"
 	"crypto/md5"
 	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -37,6 +44,43 @@ func TestCanonicalString(t *testing.T) {
 	require.Equal(t, want, CanonicalString(req))
 }
 
+func TestCanonicalString_EmptyPath(t *testing.T) {
+	for rawURL, want := range map[string]string{
+		"http://example.com":        ",,/,",
+		"http://example.com/":       ",,/,",
+		"http://example.com?x=1":    ",,/?x=1,",
+		"http://example.com/?x=1":   ",,/?x=1,",
+		"http://example.com?":       ",,/,",
+		"http://example.com/a?x=1&": ",,/a?x=1&,",
+	} {
+		req, _ := http.NewRequest("GET", rawURL, nil)
+		require.Equal(t, want, CanonicalString(req), rawURL)
+	}
+
+	// Server side, the URL is parsed from the request line.
+	for target, want := range map[string]string{
+		"/":     ",,/,",
+		"/?x=1": ",,/?x=1,",
+	} {
+		req := httptest.NewRequest("GET", target, nil)
+		require.Equal(t, want, CanonicalString(req), target)
+	}
+}
+
+func TestCanonicalString_RelativeURL(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/thing?x=1", nil)
+	require.Equal(t, "", req.URL.Host)
+	require.Equal(t, ",,/api/thing?x=1,", CanonicalString(req))
+	require.Equal(t, "GET,,,/api/thing?x=1,", CanonicalStringWithMethod(req))
+
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.NoError(t, Sign(req, "me", "secret"))
+
+	server := httptest.NewRequest("GET", "http://api.example.com/api/thing?x=1", nil)
+	server.Header = req.Header
+	require.NoError(t, Verify(server, "secret"))
+}
+
 func TestCanonicalStringEncoded(t *testing.T) {
 	req, _ := http.NewRequest("GET", "http://localhost:9000/search/K/Westcott%20Schaffer/1982-05-17", nil)
 	want := `GET,,,/search/K/Westcott%20Schaffer/1982-05-17,`
@@ -64,6 +108,51 @@ func TestCompute(t *testing.T) {
 	require.Equal(t, want, Compute(canonicalString, "secret"))
 }
 
+func TestComputeRaw(t *testing.T) {
+	canonicalString := "text/plain,WnNni3tnQAUFZDSkgFRwfQ==,/a?b=c,Thu, 19 Mar 2015 19:34:03 GMT"
+	raw := ComputeRaw(canonicalString, "secret")
+	require.Len(t, raw, 20)
+	require.Equal(t, Compute(canonicalString, "secret"), base64.StdEncoding.EncodeToString(raw))
+}
+
+func TestComputeFromReader(t *testing.T) {
+	canonicalString := "text/plain,WnNni3tnQAUFZDSkgFRwfQ==,/a?b=c,Thu, 19 Mar 2015 19:34:03 GMT"
+	sig, err := ComputeFromReader(strings.NewReader(canonicalString), "secret")
+	require.NoError(t, err)
+	require.Equal(t, Compute(canonicalString, "secret"), sig)
+
+	parts := io.MultiReader(strings.NewReader("text/plain,"), strings.NewReader("WnNni3tnQAUFZDSkgFRwfQ==,/a?b=c,"), strings.NewReader("Thu, 19 Mar 2015 19:34:03 GMT"))
+	sig, err = ComputeFromReader(parts, "secret")
+	require.NoError(t, err)
+	require.Equal(t, "cMgmUVsq4IiT7baALMM1euHnpCo=", sig)
+
+	long := strings.Repeat("x-header:value,", 100000)
+	sig, err = ComputeFromReader(strings.NewReader(long), "secret")
+	require.NoError(t, err)
+	require.Equal(t, Compute(long, "secret"), sig)
+
+	fail := errors.New("read failed")
+	_, err = ComputeFromReader(errReader{fail}, "secret")
+	require.Equal(t, fail, err)
+}
+
+func TestVerifySignature(t *testing.T) {
+	canonicalString := "text/plain,WnNni3tnQAUFZDSkgFRwfQ==,/a?b=c,Thu, 19 Mar 2015 19:34:03 GMT"
+	require.True(t, VerifySignature("cMgmUVsq4IiT7baALMM1euHnpCo=", canonicalString, "secret"))
+	require.False(t, VerifySignature("cMgmUVsq4IiT7baALMM1euHnpCo=", canonicalString, "other"))
+	require.False(t, VerifySignature("cMgmUVsq4IiT7baALMM1euHnpC", canonicalString, "secret"))
+	require.False(t, VerifySignature("not base64!", canonicalString, "secret"))
+	require.False(t, VerifySignature("", canonicalString, "secret"))
+}
+
+func BenchmarkVerifySignature(b *testing.B) {
+	canonicalString := "text/plain,WnNni3tnQAUFZDSkgFRwfQ==,/a?b=c,Thu, 19 Mar 2015 19:34:03 GMT"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		VerifySignature("cMgmUVsq4IiT7baALMM1euHnpCo=", canonicalString, "secret")
+	}
+}
+
 func TestDateForTime(t *testing.T) {
 	chi, err := time.LoadLocation("America/Chicago")
 	require.NoError(t, err)
@@ -72,10 +161,42 @@ func TestDateForTime(t *testing.T) {
 	require.Equal(t, "Thu, 19 Mar 2015 19:34:03 GMT", DateForTime(tm))
 }
 
+func TestDateForTimeLayout(t *testing.T) {
+	chi, err := time.LoadLocation("America/Chicago")
+	require.NoError(t, err)
+
+	tm := time.Date(2015, time.March, 19, 14, 34, 03, 0, chi)
+	require.Equal(t, "Thu, 19 Mar 2015 19:34:03 GMT", DateForTimeLayout(tm, http.TimeFormat))
+	require.Equal(t, "Thu, 19 Mar 2015 19:34:03 +0000", DateForTimeLayout(tm, time.RFC1123Z))
+}
+
 func TestDate(t *testing.T) {
 	require.Equal(t, DateForTime(time.Now()), Date())
 }
 
+func TestServerClockOffset(t *testing.T) {
+	_, err := ServerClockOffset(nil)
+	require.Equal(t, ErrMissingDate, err)
+
+	resp := &http.Response{Header: http.Header{}}
+	_, err = ServerClockOffset(resp)
+	require.Equal(t, ErrMissingDate, err)
+
+	resp.Header.Set("Date", "soon")
+	_, err = ServerClockOffset(resp)
+	require.Equal(t, ErrInvalidDate, err)
+
+	resp.Header.Set("Date", DateForTime(time.Now().Add(time.Hour)))
+	offset, err := ServerClockOffset(resp)
+	require.NoError(t, err)
+	require.InDelta(t, float64(time.Hour), float64(offset), float64(2*time.Second))
+
+	resp.Header.Set("Date", DateForTime(time.Now().Add(-time.Hour)))
+	offset, err = ServerClockOffset(resp)
+	require.NoError(t, err)
+	require.InDelta(t, float64(-time.Hour), float64(offset), float64(2*time.Second))
+}
+
 var sig = `as0dIwNOHAEi7yVHH+QM2kjO5Xw=`
 
 func TestSign(t *testing.T) {
@@ -192,6 +313,45 @@ func TestParse(t *testing.T) {
 	require.Equal(t, "sig", sig)
 }
 
+func TestParse_Base64AccessID(t *testing.T) {
+	id, sig, err := Parse("APIAuth dG9rZW4/a2V5+w==:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	require.NoError(t, err)
+	require.Equal(t, "dG9rZW4/a2V5+w==", id)
+	require.Equal(t, "N7N1BXAWv6+RXos4vSAAd7D0XJY=", sig)
+
+	id, sig, err = Parse("APIAuth a/b=:sig:with:colons")
+	require.NoError(t, err)
+	require.Equal(t, "a/b=", id)
+	require.Equal(t, "sig:with:colons", sig)
+}
+
+func TestValidateHeader(t *testing.T) {
+	require.NoError(t, ValidateHeader("APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY="))
+	require.NoError(t, ValidateHeader("APIAuth a/b=:N7N1BXAWv6+RXos4vSAAd7D0XJY="))
+
+	for _, header := range []string{
+		"",
+		"NotAPIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=",
+		"APIAuth :N7N1BXAWv6+RXos4vSAAd7D0XJY=",
+		"APIAuth me:",
+		"APIAuth me:me",
+		"APIAuth me:not base64 at all",
+		"APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY",
+		"APIAuth me:cMgmUVsq4IiT7baALMM1euHnpCpjTWdtVVZzcTRJaVQ3YmFBTE1NMWV1SG5wQ289",
+		"APIAuth-HMAC-SHA256 you:1426880260:N7N1BXAWv6+RXos4vSAAd7D0XJY=",
+		"APIAuth-HMAC-SHA1 you:1426880260:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=",
+		"APIAuth-HMAC-SHA256 you:later:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=",
+		"APIAuth-HMAC-SHA256 you:1426880260:",
+	} {
+		require.Equal(t, ErrMalformedHeader, ValidateHeader(header), header)
+	}
+
+	// V2 signatures are checked against the declared algorithm.
+	require.NoError(t, ValidateHeader("APIAuth-HMAC-SHA256 you:1426880260:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU="))
+	require.NoError(t, ValidateHeader("APIAuth-HMAC-SHA1 you:1426880260:N7N1BXAWv6+RXos4vSAAd7D0XJY="))
+	require.Equal(t, ErrUnsupportedAlgorithm, ValidateHeader("APIAuth-HMAC-MD5 you:1426880260:N7N1BXAWv6+RXos4vSAAd7D0XJY="))
+}
+
 func TestVerify(t *testing.T) {
 	req, _ := http.NewRequest("GET", "http://example.com", nil)
 	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
@@ -233,6 +393,109 @@ func TestVerify_BadAuthorizationHeader(t *testing.T) {
 	require.Error(t, Verify(req, "secret"))
 }
 
+func TestVerifySafe(t *testing.T) {
+	get, _ := http.NewRequest("GET", "http://example.com", nil)
+	get.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	get.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	require.NoError(t, VerifySafe(get, "secret"))
+
+	post, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader([]byte(`post body`)))
+	post.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	post.Header.Set("Content-Type", "text/plain")
+	post.Header.Set("Content-MD5", "WnNni3tnQAUFZDSkgFRwfQ==")
+	post.Header.Set("Authorization", "APIAuth me:/Z/MqEW+v23Cm3w3Ra2mMGH9KFw=")
+	require.NoError(t, Verify(post, "secret"))
+	require.Equal(t, ErrSignatureMismatch, VerifySafe(post, "secret"))
+
+	post.Header.Del("Authorization")
+	require.NoError(t, SignWithMethod(post, "me", "secret"))
+	require.NoError(t, VerifySafe(post, "secret"))
+}
+
+func TestVerifyAccessID(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+
+	require.NoError(t, VerifyAccessID(req, "me", "secret"))
+	require.Equal(t, ErrAccessIDMismatch, VerifyAccessID(req, "you", "secret"))
+	require.Equal(t, ErrAccessIDMismatch, VerifyAccessID(req, "m", "secret"))
+	require.Equal(t, ErrSignatureMismatch, VerifyAccessID(req, "me", "other"))
+}
+
+func TestVerifyWithAAD(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+
+	require.NoError(t, SignWithAAD(req, "me", "secret", nil))
+	require.Equal(t, "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=", req.Header.Get("Authorization"))
+	require.NoError(t, VerifyWithAAD(req, "secret", []byte{}))
+
+	req.Header.Del("Authorization")
+	require.NoError(t, SignWithAAD(req, "me", "secret", []byte("session-1")))
+	require.NoError(t, VerifyWithAAD(req, "secret", []byte("session-1")))
+	require.Equal(t, ErrSignatureMismatch, VerifyWithAAD(req, "secret", []byte("session-2")))
+	require.Equal(t, ErrSignatureMismatch, VerifyWithAAD(req, "secret", nil))
+	require.Equal(t, ErrSignatureMismatch, Verify(req, "secret"))
+}
+
+func TestVerifyDescription(t *testing.T) {
+	headers := map[string]string{
+		"content-type":  "text/plain",
+		"Content-MD5":   "WnNni3tnQAUFZDSkgFRwfQ==",
+		"Date":          "Thu, 19 Mar 2015 19:24:24 GMT",
+		"Authorization": "APIAuth me:43DQKYwiMx3swEwa3raDq5tPxIo=",
+	}
+	require.NoError(t, VerifyDescription("POST", "http://example.com/some/path?x=1&b=2", headers, "secret"))
+	require.Equal(t, ErrSignatureMismatch, VerifyDescription("PUT", "http://example.com/some/path?x=1&b=2", headers, "secret"))
+	require.Equal(t, ErrSignatureMismatch, VerifyDescription("POST", "http://example.com/some/path", headers, "secret"))
+	require.Error(t, VerifyDescription("POST", "http://example.com/%zz", headers, "secret"))
+
+	delete(headers, "Date")
+	require.Equal(t, ErrMissingDate, VerifyDescription("POST", "http://example.com/some/path?x=1&b=2", headers, "secret"))
+}
+
+func TestVerifyRaw(t *testing.T) {
+	headers := http.Header{}
+	headers.Set("Content-Type", "text/plain")
+	headers.Set("Content-MD5", "WnNni3tnQAUFZDSkgFRwfQ==")
+	headers.Set("Date", "Thu, 19 Mar 2015 19:24:24 GMT")
+	headers.Set("Authorization", "APIAuth me:43DQKYwiMx3swEwa3raDq5tPxIo=")
+	require.NoError(t, VerifyRaw("POST", "/some/path?x=1&b=2", headers, "secret"))
+	require.NoError(t, VerifyRaw("POST", "http://example.com/some/path?x=1&b=2", headers, "secret"))
+	require.Equal(t, ErrSignatureMismatch, VerifyRaw("PUT", "/some/path?x=1&b=2", headers, "secret"))
+	require.Equal(t, ErrSignatureMismatch, VerifyRaw("POST", "/some/path", headers, "secret"))
+	require.Error(t, VerifyRaw("POST", "some/path", headers, "secret"))
+
+	headers.Del("Date")
+	require.Equal(t, ErrMissingDate, VerifyRaw("POST", "/some/path?x=1&b=2", headers, "secret"))
+}
+
+func TestMissingHeaders(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader([]byte(`post body`)))
+	req.Header.Set("Authorization", "APIAuth me:/Z/MqEW+v23Cm3w3Ra2mMGH9KFw=")
+	require.Equal(t, []string{"Date", "Content-Type", "Content-MD5"}, MissingHeaders(req))
+	require.Equal(t, ErrMissingDate, Verify(req, "secret"))
+
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.Equal(t, []string{"Content-Type", "Content-MD5"}, MissingHeaders(req))
+	require.Equal(t, ErrMissingContentType, Verify(req, "secret"))
+
+	req.Header.Set("Content-Type", "text/plain")
+	require.Equal(t, []string{"Content-MD5"}, MissingHeaders(req))
+	require.Equal(t, ErrMissingContentMD5, Verify(req, "secret"))
+
+	req.Header.Set("Content-MD5", "WnNni3tnQAUFZDSkgFRwfQ==")
+	require.Empty(t, MissingHeaders(req))
+	require.NoError(t, Verify(req, "secret"))
+	require.Empty(t, MissingHeaders(nil))
+
+	get, _ := http.NewRequest("GET", "/", nil)
+	v := Verifier{Canonicalizer: Canonicalizer{SignedHeaders: []string{"Accept"}, IncludeHost: true}, RequireContentMD5: true}
+	require.Equal(t, []string{"Date", "Accept", "Host", "Content-Type", "Content-MD5"}, v.MissingHeaders(get))
+	require.Equal(t, []string{"Date", "Accept", "Host"}, v.Canonicalizer.MissingHeaders(get))
+}
+
 func TestVerify_NoDate(t *testing.T) {
 	req, _ := http.NewRequest("GET", "http://example.com", nil)
 	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
@@ -268,3 +531,269 @@ func TestVerify_AcceptIncorrectMD5(t *testing.T) {
 
 	require.NoError(t, Verify(req, "secret"))
 }
+
+func TestUpgradeSignature(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com/some/path?x=1&b=2", nil)
+	req.Header.Add("Content-Type", "text/plain")
+	req.Header.Add("Content-MD5", "WnNni3tnQAUFZDSkgFRwfQ==")
+	req.Header.Add("Date", "Thu, 19 Mar 2015 19:24:24 GMT")
+
+	require.NoError(t, Sign(req, "me", "secret"))
+	require.NoError(t, UpgradeSignature(req, "secret"))
+	require.Equal(t, `APIAuth me:43DQKYwiMx3swEwa3raDq5tPxIo=`, req.Header.Get("Authorization"))
+
+	// Already upgraded: the legacy signature no longer matches.
+	require.Error(t, UpgradeSignature(req, "secret"))
+	require.Equal(t, `APIAuth me:43DQKYwiMx3swEwa3raDq5tPxIo=`, req.Header.Get("Authorization"))
+}
+
+func TestVerifyConstantTime(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	require.NoError(t, VerifyConstantTime(req, "secret"))
+
+	req.Header.Set("Authorization", "APIAuth me:43DQKYwiMx3swEwa3raDq5tPxIo=")
+	require.EqualError(t, VerifyConstantTime(req, "secret"), "Signature mismatch")
+
+	req.Header.Set("Authorization", "something else")
+	require.Error(t, VerifyConstantTime(req, "secret"))
+
+	req.Header.Del("Authorization")
+	require.EqualError(t, VerifyConstantTime(req, "secret"), "Authorization header not set")
+
+	req.Header.Del("Date")
+	require.EqualError(t, VerifyConstantTime(req, "secret"), "No Date header present")
+
+	// The first missing header is reported, in the order MissingHeaders
+	// lists them.
+	post, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader([]byte(`post body`)))
+	require.Equal(t, ErrMissingDate, VerifyConstantTime(post, "secret"))
+	post.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.Equal(t, ErrMissingContentType, VerifyConstantTime(post, "secret"))
+	post.Header.Set("Content-Type", "text/plain")
+	require.Equal(t, ErrMissingContentMD5, VerifyConstantTime(post, "secret"))
+	post.Header.Set("Content-MD5", "WnNni3tnQAUFZDSkgFRwfQ==")
+	post.Header.Set("Authorization", "APIAuth me:/Z/MqEW+v23Cm3w3Ra2mMGH9KFw=")
+	require.NoError(t, VerifyConstantTime(post, "secret"))
+}
+
+func TestNilRequest(t *testing.T) {
+	require.Equal(t, "", CanonicalString(nil))
+	require.Equal(t, "", CanonicalStringWithMethod(nil))
+
+	require.Equal(t, ErrNilRequest, Sign(nil, "id", "secret"))
+	require.Equal(t, ErrNilRequest, Verify(nil, "secret"))
+	require.Equal(t, ErrNilRequest, VerifyWithBodyReader(nil, "secret"))
+	require.Equal(t, ErrNilRequest, VerifyConstantTime(nil, "secret"))
+	require.Equal(t, ErrNilRequest, UpgradeSignature(nil, "secret"))
+	require.Equal(t, ErrNilRequest, SetContentMD5(nil))
+	require.Equal(t, ErrNilRequest, VerifyContentMD5(nil))
+	require.Equal(t, ErrNilRequest, EnsureContentMD5(nil))
+	require.Equal(t, ErrNilRequest, SetDigest(nil))
+	require.Equal(t, ErrNilRequest, VerifyDigest(nil))
+
+	_, err := VerifyAndWrapBody(nil, "secret")
+	require.Equal(t, ErrNilRequest, err)
+}
+
+func TestNilHeader(t *testing.T) {
+	req := &http.Request{Method: "GET"}
+	require.Equal(t, ",,/,", CanonicalString(req))
+	require.Equal(t, "GET,,,/,", CanonicalStringWithMethod(req))
+	require.Equal(t, ErrMissingDate, Sign(req, "id", "secret"))
+	require.Equal(t, ErrMissingDate, Verify(&http.Request{Method: "GET"}, "secret"))
+
+	req = &http.Request{Method: "POST", Body: ioutil.NopCloser(bytes.NewBufferString("body"))}
+	require.NoError(t, SetContentMD5(req))
+	require.Equal(t, base64md5([]byte("body")), req.Header.Get("Content-MD5"))
+
+	// A request without a URL is treated as one for `/`.
+	req = &http.Request{Method: "GET", Header: http.Header{}}
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.Equal(t, ",,/,Fri, 20 Mar 2015 19:37:40 GMT", CanonicalString(req))
+	require.NoError(t, Sign(req, "id", "secret"))
+	require.NoError(t, Verify(req, "secret"))
+}
+
+func benchmarkRequest() *http.Request {
+	req, _ := http.NewRequest("POST", "http://example.com/some/path?x=1&b=2", nil)
+	req.Header.Add("Content-Type", "text/plain")
+	req.Header.Add("Content-MD5", "WnNni3tnQAUFZDSkgFRwfQ==")
+	req.Header.Add("Date", "Thu, 19 Mar 2015 19:24:24 GMT")
+	return req
+}
+
+func BenchmarkCanonicalString(b *testing.B) {
+	req := benchmarkRequest()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		CanonicalString(req)
+	}
+}
+
+func BenchmarkCanonicalStringWithMethod(b *testing.B) {
+	req := benchmarkRequest()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		CanonicalStringWithMethod(req)
+	}
+}
+
+// Verify tries the canonical string with the method first, so a request
+// signed without it costs two HMAC computations rather than one. Only
+// accepting the method-signed format, as in the Strict benchmark, caps
+// every request (and every forgery) at one.
+func BenchmarkVerify(b *testing.B) {
+	b.Run("WithMethod", func(b *testing.B) {
+		req := benchmarkRequest()
+		SignWithMethod(req, "me", "secret")
+		benchmarkVerify(b, &Verifier{Secret: "secret"}, req)
+	})
+
+	b.Run("Legacy", func(b *testing.B) {
+		req := benchmarkRequest()
+		Sign(req, "me", "secret")
+		benchmarkVerify(b, &Verifier{Secret: "secret"}, req)
+	})
+
+	b.Run("Mismatch", func(b *testing.B) {
+		req := benchmarkRequest()
+		req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+		benchmarkVerify(b, &Verifier{Secret: "secret"}, req)
+	})
+
+	b.Run("Strict", func(b *testing.B) {
+		req := benchmarkRequest()
+		SignWithMethod(req, "me", "secret")
+		v := &Verifier{Secret: "secret", Builders: []CanonicalBuilder{WithMethod(DefaultBuilder)}}
+		benchmarkVerify(b, v, req)
+	})
+}
+
+func benchmarkVerify(b *testing.B, v *Verifier, req *http.Request) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		v.Verify(req)
+	}
+}
+
+func TestRequestFromLog(t *testing.T) {
+	for _, rawURL := range []string{
+		"http://example.com",
+		"http://example.com/some/path?x=1&b=2",
+		"http://example.com/a%2Fb/c%20d?q=a+b&r=%2B",
+		"http://example.com/caf%C3%A9?",
+	} {
+		body := []byte(`post body`)
+		req, _ := http.NewRequest("POST", rawURL, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Content-MD5", base64md5(body))
+		req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+		require.NoError(t, SignWithMethod(req, "me", "secret"))
+
+		logged := RequestFromLog("POST", req.URL.RequestURI(), "text/plain", base64md5(body), "Fri, 20 Mar 2015 19:37:40 GMT", req.Header.Get("Authorization"))
+		require.Equal(t, CanonicalStringWithMethod(req), CanonicalStringWithMethod(logged), rawURL)
+		require.NoError(t, Verify(logged, "secret"), rawURL)
+
+		// An absolute request target works too.
+		logged = RequestFromLog("POST", rawURL, "text/plain", base64md5(body), "Fri, 20 Mar 2015 19:37:40 GMT", req.Header.Get("Authorization"))
+		require.NoError(t, Verify(logged, "secret"), rawURL)
+		require.Equal(t, "example.com", logged.Host)
+
+		logged = RequestFromLog("PUT", req.URL.RequestURI(), "text/plain", base64md5(body), "Fri, 20 Mar 2015 19:37:40 GMT", req.Header.Get("Authorization"))
+		require.Equal(t, ErrSignatureMismatch, Verify(logged, "secret"), rawURL)
+	}
+
+	logged := RequestFromLog("GET", "/", "", "", "", "")
+	require.Empty(t, logged.Header)
+	require.Equal(t, ErrMissingDate, Verify(logged, "secret"))
+}
+
+// TestConcurrentSignVerify is meant to be run with -race: shared Signers,
+// Verifiers and package state must be safe for concurrent use.
+func TestConcurrentSignVerify(t *testing.T) {
+	s := Signer{AccessID: "me", Secret: "secret", WithMethod: true}
+	v := Verifier{
+		KeyFunc: CachingKeyFunc(func(string) (string, error) { return "secret", nil }, time.Minute, 10),
+		MaxPast: time.Minute,
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			req, _ := http.NewRequest("POST", "http://example.com/items?n="+strconv.Itoa(i), bytes.NewBufferString("hello"))
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header.Set("Date", Date())
+			if err := s.SetContentMD5(req); err != nil {
+				errs <- err
+				return
+			}
+
+			var err error
+			switch i % 3 {
+			case 0:
+				err = s.Sign(req)
+			case 1:
+				err = SignWithMethod(req, "me", "secret")
+			case 2:
+				err = s.SignV2(req, HMACSHA256)
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			if i%3 == 2 {
+				err = v.VerifyV2(req)
+			} else {
+				err = v.Verify(req)
+			}
+			if err == nil {
+				err = v.VerifyContentMD5(req)
+			}
+			errs <- err
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestAccessID(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	id, err := AccessID(req)
+	require.Equal(t, ErrMissingAuthorization, err)
+	require.Equal(t, "", id)
+
+	req.Header.Set("Authorization", "APIAuth me:not-even-base64")
+	id, err = AccessID(req)
+	require.NoError(t, err)
+	require.Equal(t, "me", id)
+
+	req.Header.Set("Authorization", "APIAuth-HMAC-SHA256 you:1426880260:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=")
+	id, err = AccessID(req)
+	require.NoError(t, err)
+	require.Equal(t, "you", id)
+
+	req.Header.Set("Authorization", "Bearer me")
+	_, err = AccessID(req)
+	require.Equal(t, ErrMalformedHeader, err)
+
+	// The access ID is returned as it is, whatever a Verifier's
+	// ValidateAccessID would make of it.
+	req.Header.Set("Authorization", "APIAuth ab+c/d=:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	id, err = AccessID(req)
+	require.NoError(t, err)
+	require.Equal(t, "ab+c/d=", id)
+
+	_, err = AccessID(nil)
+	require.Equal(t, ErrNilRequest, err)
+}
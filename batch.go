@@ -0,0 +1,88 @@
+package apiauth
+
+import (
+	"context"
+	"net/http"
+)
+
+// A VerifyResult is the outcome of verifying one request of a stream.
+type VerifyResult struct {
+	Request *http.Request
+
+	// AccessID is the access ID the request was signed under, if it
+	// verified.
+	AccessID string
+
+	Err error
+}
+
+// VerifyStream verifies each request received from reqs as in Verify,
+// using the secret keyFunc returns for its access ID, and sends the
+// results, in order, on the returned channel; see Verifier.VerifyStream.
+func VerifyStream(ctx context.Context, reqs <-chan *http.Request, keyFunc KeyFunc) <-chan VerifyResult {
+	v := Verifier{KeyFunc: keyFunc}
+	return v.VerifyStream(ctx, reqs)
+}
+
+// VerifyStream verifies each request received from reqs as
+// VerifyAndIdentify does, one at a time, and sends the results, in the
+// order of the requests, on the returned channel, for bursts of requests
+// from few clients. The secret KeyFunc returns for each access ID is
+// looked up once and held for the life of the stream; errors from KeyFunc,
+// including ErrUnknownAccessID, are not held, so that a stream of made-up
+// access IDs does not grow it. Secrets rotated during a stream are
+// therefore not seen by it.
+//
+// The returned channel is closed once reqs is closed and every result has
+// been sent, or once ctx is done, when any requests not yet verified are
+// dropped. Callers must receive every result, or cancel ctx, for the
+// stream to finish. The Verifier must not be modified until it has.
+func (v *Verifier) VerifyStream(ctx context.Context, reqs <-chan *http.Request) <-chan VerifyResult {
+	stream := *v
+	if v.KeyFunc != nil {
+		stream.KeyFunc = streamKeyFunc(v.KeyFunc)
+	}
+
+	results := make(chan VerifyResult)
+	go func() {
+		defer close(results)
+
+		for {
+			var r *http.Request
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case r, ok = <-reqs:
+				if !ok {
+					return
+				}
+			}
+
+			id, _, err := stream.VerifyAndIdentify(r)
+			select {
+			case <-ctx.Done():
+				return
+			case results <- VerifyResult{Request: r, AccessID: id, Err: err}:
+			}
+		}
+	}()
+	return results
+}
+
+// streamKeyFunc returns a key function holding the secrets inner returns
+// for as long as it is used. It is not safe for concurrent use.
+func streamKeyFunc(inner KeyFunc) KeyFunc {
+	held := make(map[string]string)
+	return func(accessID string) (string, error) {
+		if secret, ok := held[accessID]; ok {
+			return secret, nil
+		}
+
+		secret, err := inner(accessID)
+		if err == nil {
+			held[accessID] = secret
+		}
+		return secret, err
+	}
+}
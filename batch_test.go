@@ -0,0 +1,87 @@
+package apiauth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyStream(t *testing.T) {
+	calls := map[string]int{}
+	keyFunc := func(id string) (string, error) {
+		calls[id]++
+		switch id {
+		case "me", "you":
+			return "secret", nil
+		case "flaky":
+			return "", errUnknownKey
+		}
+		return "", ErrUnknownAccessID
+	}
+
+	newRequest := func(id string) *http.Request {
+		req, _ := http.NewRequest("GET", "http://example.com/items", nil)
+		req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+		require.NoError(t, Sign(req, id, "secret"))
+		return req
+	}
+
+	var sent []*http.Request
+	for _, id := range []string{"me", "me", "you", "unknown", "me", "unknown", "flaky", "flaky"} {
+		sent = append(sent, newRequest(id))
+	}
+	tampered := newRequest("me")
+	tampered.URL.Path = "/other"
+	sent = append(sent, tampered)
+
+	reqs := make(chan *http.Request)
+	go func() {
+		for _, r := range sent {
+			reqs <- r
+		}
+		close(reqs)
+	}()
+
+	var results []VerifyResult
+	for res := range VerifyStream(context.Background(), reqs, keyFunc) {
+		results = append(results, res)
+	}
+
+	require.Len(t, results, len(sent))
+	for i, res := range results {
+		require.Same(t, sent[i], res.Request)
+	}
+
+	require.Equal(t, "me", results[0].AccessID)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, "you", results[2].AccessID)
+	require.Equal(t, ErrUnknownAccessID, results[3].Err)
+	require.Equal(t, errUnknownKey, results[6].Err)
+	require.Equal(t, ErrSignatureMismatch, results[8].Err)
+	require.Empty(t, results[8].AccessID)
+
+	// Secrets are looked up once per stream; failed lookups, even of
+	// unknown access IDs, are not held.
+	require.Equal(t, map[string]int{"me": 1, "you": 1, "unknown": 2, "flaky": 2}, calls)
+}
+
+func TestVerifyStream_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reqs := make(chan *http.Request)
+	results := VerifyStream(ctx, reqs, func(string) (string, error) { return "secret", nil })
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	reqs <- req
+	require.NoError(t, (<-results).Err)
+
+	// A result nobody receives is dropped once ctx is done, and the
+	// stream finishes without reqs being closed.
+	reqs <- req
+	cancel()
+	for range results {
+	}
+}
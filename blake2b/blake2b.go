@@ -0,0 +1,37 @@
+// Package blake2b registers keyed BLAKE2b-512 as the apiauth.BLAKE2b512
+// algorithm for the V2 scheme. Import it for its side effect:
+//
+//	import _ "github.com/pd/apiauth/blake2b"
+//
+// Requests are then signed with it by passing apiauth.BLAKE2b512 to
+// Signer.SignV2, and verified by VerifyV2 from the algorithm named in
+// their Authorization header.
+package blake2b
+
+import (
+	"hash"
+
+	"github.com/pd/apiauth"
+	"golang.org/x/crypto/blake2b"
+)
+
+func init() {
+	apiauth.RegisterAlgorithm(apiauth.BLAKE2b512, New)
+}
+
+// New returns a BLAKE2b-512 MAC keyed by key. BLAKE2b takes keys of at
+// most 64 bytes, so longer keys are first hashed with unkeyed
+// BLAKE2b-512, as HMAC does with keys longer than its block size.
+func New(key []byte) hash.Hash {
+	if len(key) > blake2b.Size {
+		sum := blake2b.Sum512(key)
+		key = sum[:]
+	}
+
+	h, err := blake2b.New512(key)
+	if err != nil {
+		// Unreachable: the key is at most blake2b.Size bytes.
+		panic(err)
+	}
+	return h
+}
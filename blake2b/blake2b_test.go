@@ -0,0 +1,57 @@
+package blake2b
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pd/apiauth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	// Keyed test vectors from the BLAKE2 reference implementation's
+	// blake2b-kat.txt.
+	key := make([]byte, 64)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	for in, want := range map[string]string{
+		"":             "10ebb67700b1868efb4417987acf4690ae9d972fb7a590c2f02871799aaa4786b5e996e8f0f4eb981fc214b005f42d2ff4233499391653df7aefcbc13fc51568",
+		"\x00\x01\x02": "33d0825dddf7ada99b0e7e307104ad07ca9cfd9692214f1561356315e784f3e5a17e364ae9dbb14cb2036df932b77f4b292761365fb328de7afdc6d8998f5fc1",
+	} {
+		mac := New(key)
+		mac.Write([]byte(in))
+		require.Equal(t, want, hex.EncodeToString(mac.Sum(nil)))
+	}
+}
+
+func TestSignV2(t *testing.T) {
+	const header = "APIAuth-BLAKE2b-512 me:1426880260:WvMM+oeI9dWJrteox2E40mRYDk41lktDj0vtip4V/3+vLWBjRZ9E5cPX//IZI+7c3LO0uvFVGsMkvpzpFVjtyg=="
+
+	signed := time.Unix(1426880260, 0)
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+
+	s := apiauth.Signer{AccessID: "me", Secret: "secret", ClockOffset: time.Until(signed)}
+	require.NoError(t, s.SignV2(req, apiauth.BLAKE2b512))
+	require.Equal(t, header, req.Header.Get("Authorization"))
+
+	keyFunc := func(string) (string, error) { return "secret", nil }
+	v := apiauth.Verifier{KeyFunc: keyFunc, Now: func() time.Time { return signed }}
+	require.NoError(t, v.VerifyV2(req))
+
+	v.KeyFunc = func(string) (string, error) { return "other", nil }
+	require.Equal(t, apiauth.ErrSignatureMismatch, v.VerifyV2(req))
+
+	// Secrets longer than a BLAKE2b key are hashed first.
+	long := strings.Repeat("x", 100)
+	req.Header.Del("Authorization")
+	s.Secret = long
+	s.ClockOffset = time.Until(signed)
+	require.NoError(t, s.SignV2(req, apiauth.BLAKE2b512))
+	require.Equal(t, "APIAuth-BLAKE2b-512 me:1426880260:EKCNz9EeP8sDREuMEAhj02Lbowmg86vi9rEQNZWP0zQY2oqsTiLiVbt+a88blj7QPCa69cdxRz5dSJb1a6r1Ew==", req.Header.Get("Authorization"))
+}
@@ -0,0 +1,430 @@
+package apiauth
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// DigestHeader is the name of the RFC 3230 instance digest header. Set a
+// Canonicalizer's IntegrityHeader to it to sign the digest in place of
+// Content-MD5.
+const DigestHeader = "Digest"
+
+// DefaultMaxBodySize is the number of body bytes the body-reading
+// helpers will buffer when no MaxBodySize is configured.
+const DefaultMaxBodySize = 10 << 20
+
+// SetContentMD5 computes the MD5 of the request body and stores it,
+// base64-encoded, in the Content-MD5 header. The body is read in full
+// and replaced, so it can still be sent or read afterwards.
+func SetContentMD5(r *http.Request) error {
+	return setContentMD5(r, DefaultMaxBodySize, false, false)
+}
+
+// VerifyContentMD5 reads the request body and checks it against the
+// Content-MD5 header. The body is replaced, so downstream handlers can
+// still read it.
+func VerifyContentMD5(r *http.Request) error {
+	return verifyContentMD5(r, DefaultMaxBodySize, false, false, false)
+}
+
+// EnsureContentMD5 sets the Content-MD5 header from the request body if
+// it is absent, and leaves it alone if it is present and correct. If it
+// is present but does not match the body, an error is returned rather
+// than letting a stale checksum be signed. The body is read through
+// r.GetBody when set, and is otherwise buffered and replaced.
+func EnsureContentMD5(r *http.Request) error {
+	if err := checkRequest(r); err != nil {
+		return err
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	var body []byte
+	var err error
+	if r.GetBody != nil {
+		body, err = readGetBody(r, DefaultMaxBodySize)
+	} else {
+		body, err = readBody(r, DefaultMaxBodySize)
+	}
+	if err != nil {
+		return err
+	}
+
+	sum := contentMD5(body)
+	existing := r.Header.Get("Content-MD5")
+	if existing == "" {
+		r.Header.Set("Content-MD5", sum)
+		return nil
+	}
+
+	if existing != sum {
+		return ErrContentMD5Mismatch
+	}
+
+	return nil
+}
+
+// CheckContentMD5 checks an already-read request body against the
+// Content-MD5 header, comparing its base64 encoding exactly. It is the
+// body check that Verify leaves to the caller, for handlers that read the
+// body themselves; unlike VerifyContentMD5, it does not touch r.Body.
+func CheckContentMD5(r *http.Request, body []byte) error {
+	if err := checkRequest(r); err != nil {
+		return err
+	}
+	return checkContentMD5(r.Header.Get("Content-MD5"), body)
+}
+
+// SetDigest computes the SHA-256 digest of the request body and stores
+// it in the Digest header as `SHA-256=<base64>`. The body is read in
+// full and replaced, so it can still be sent or read afterwards.
+func SetDigest(r *http.Request) error {
+	return setDigest(r, DefaultMaxBodySize)
+}
+
+// VerifyDigest reads the request body and checks it against the SHA-256
+// value in the Digest header. Digests using other algorithms are ignored;
+// an error is returned if no SHA-256 digest is present. The body is
+// replaced, so downstream handlers can still read it.
+func VerifyDigest(r *http.Request) error {
+	return verifyDigest(r, DefaultMaxBodySize)
+}
+
+// SetContentMD5 is as the package-level SetContentMD5, but reads at
+// most s.MaxBodySize bytes of the body, hashes it as ComputeMD5JSON
+// does if JSONContentMD5 is set, and hashes it decompressed if
+// DecodedContentMD5 is set.
+func (s *Signer) SetContentMD5(r *http.Request) error {
+	return setContentMD5(r, maxBodySize(s.MaxBodySize), s.JSONContentMD5, s.DecodedContentMD5)
+}
+
+// SetDigest is as the package-level SetDigest, but reads at most
+// s.MaxBodySize bytes of the body.
+func (s *Signer) SetDigest(r *http.Request) error {
+	return setDigest(r, maxBodySize(s.MaxBodySize))
+}
+
+// VerifyContentMD5 is as the package-level VerifyContentMD5, but reads
+// at most v.MaxBodySize bytes of the body, accepts the encodings allowed
+// by NormalizeContentMD5 when it is set, hashes the body as
+// ComputeMD5JSON does if JSONContentMD5 is set, and decompresses it
+// before hashing if DecodedContentMD5 is set. The body is restored as it
+// was received, still compressed.
+func (v *Verifier) VerifyContentMD5(r *http.Request) error {
+	return verifyContentMD5(r, maxBodySize(v.MaxBodySize), v.NormalizeContentMD5, v.JSONContentMD5, v.DecodedContentMD5)
+}
+
+// VerifyDigest is as the package-level VerifyDigest, but reads at most
+// v.MaxBodySize bytes of the body.
+func (v *Verifier) VerifyDigest(r *http.Request) error {
+	return verifyDigest(r, maxBodySize(v.MaxBodySize))
+}
+
+func setContentMD5(r *http.Request, limit int64, jsonBody, decoded bool) error {
+	if err := checkRequest(r); err != nil {
+		return err
+	}
+
+	body, err := readBody(r, limit)
+	if err != nil {
+		return err
+	}
+
+	if decoded {
+		if body, err = decodeBody(r, body, limit); err != nil {
+			return err
+		}
+	}
+
+	sum, err := bodyMD5(body, jsonBody)
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Content-MD5", sum)
+	return nil
+}
+
+func verifyContentMD5(r *http.Request, limit int64, normalize, jsonBody, decoded bool) error {
+	if err := checkRequest(r); err != nil {
+		return err
+	}
+
+	want := r.Header.Get("Content-MD5")
+	if normalize {
+		want = normalizeMD5(want)
+	}
+
+	if want == "" {
+		return ErrMissingContentMD5
+	}
+
+	body, err := readBody(r, limit)
+	if err != nil {
+		return err
+	}
+
+	if decoded {
+		if body, err = decodeBody(r, body, limit); err != nil {
+			return err
+		}
+	}
+
+	if !jsonBody {
+		return checkContentMD5(want, body)
+	}
+
+	sum, err := ComputeMD5JSON(body)
+	if err != nil {
+		return err
+	}
+
+	if sum != want {
+		return ErrContentMD5Mismatch
+	}
+	return nil
+}
+
+func checkContentMD5(want string, body []byte) error {
+	if want == "" {
+		return ErrMissingContentMD5
+	}
+
+	if contentMD5(body) != want {
+		return ErrContentMD5Mismatch
+	}
+
+	return nil
+}
+
+// ComputeMD5JSON returns the base64-encoded MD5 of a JSON body after
+// normalizing it: object keys are sorted and insignificant whitespace is
+// removed, so that bodies serialized differently by client and server
+// agree. Strings are re-escaped as encoding/json escapes them, and numbers
+// are kept exactly as written, so 1 and 1.0 still differ. Both ends must
+// normalize identically, so the result matches neither the Ruby gem nor
+// any other implementation. Bodies that are not a single JSON value fail
+// with ErrInvalidJSON.
+func ComputeMD5JSON(body []byte) (string, error) {
+	normalized, err := normalizeJSON(body)
+	if err != nil {
+		return "", err
+	}
+	return contentMD5(normalized), nil
+}
+
+func normalizeJSON(body []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, ErrInvalidJSON
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, ErrInvalidJSON
+	}
+
+	return json.Marshal(value)
+}
+
+func bodyMD5(body []byte, jsonBody bool) (string, error) {
+	if jsonBody {
+		return ComputeMD5JSON(body)
+	}
+	return contentMD5(body), nil
+}
+
+// decodeBody returns the body read from a request with its
+// Content-Encoding, which may be gzip or identity, undone. At most limit
+// bytes are decompressed; larger bodies fail with ErrBodyTooLarge.
+// Other encodings fail with ErrUnsupportedContentEncoding, and corrupt
+// data with the error from compress/gzip.
+func decodeBody(r *http.Request, body []byte, limit int64) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+	default:
+		return nil, ErrUnsupportedContentEncoding
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := ioutil.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(decoded)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+	return decoded, nil
+}
+
+func setDigest(r *http.Request, limit int64) error {
+	if err := checkRequest(r); err != nil {
+		return err
+	}
+
+	body, err := readBody(r, limit)
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set(DigestHeader, "SHA-256="+digestSHA256(body))
+	return nil
+}
+
+func verifyDigest(r *http.Request, limit int64) error {
+	if err := checkRequest(r); err != nil {
+		return err
+	}
+
+	header := r.Header.Get(DigestHeader)
+	if header == "" {
+		return missingHeader(DigestHeader)
+	}
+
+	want := sha256Digest(header)
+	if want == "" {
+		return ErrUnsupportedDigest
+	}
+
+	body, err := readBody(r, limit)
+	if err != nil {
+		return err
+	}
+
+	if digestSHA256(body) != want {
+		return ErrDigestMismatch
+	}
+
+	return nil
+}
+
+// sha256Digest returns the SHA-256 value from a Digest header, or the
+// empty string if it has none.
+func sha256Digest(header string) string {
+	for _, instance := range strings.Split(header, ",") {
+		tokens := strings.SplitN(strings.TrimSpace(instance), "=", 2)
+		if len(tokens) == 2 && strings.EqualFold(tokens[0], "SHA-256") {
+			return tokens[1]
+		}
+	}
+	return ""
+}
+
+func contentMD5(body []byte) string {
+	sum := md5.Sum(body)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// normalizeMD5 re-encodes an MD5 given in any of the common base64
+// variants, or in hex, as standard padded base64. Values that do not
+// decode to an MD5 are returned unchanged.
+func normalizeMD5(value string) string {
+	encodings := []*base64.Encoding{
+		base64.StdEncoding,
+		base64.RawStdEncoding,
+		base64.URLEncoding,
+		base64.RawURLEncoding,
+	}
+
+	for _, enc := range encodings {
+		if sum, err := enc.DecodeString(value); err == nil && len(sum) == md5.Size {
+			return base64.StdEncoding.EncodeToString(sum)
+		}
+	}
+
+	if sum, err := hex.DecodeString(value); err == nil && len(sum) == md5.Size {
+		return base64.StdEncoding.EncodeToString(sum)
+	}
+
+	return value
+}
+
+func digestSHA256(body []byte) string {
+	sum := sha256.Sum256(body)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func maxBodySize(n int64) int64 {
+	if n <= 0 {
+		return DefaultMaxBodySize
+	}
+	return n
+}
+
+// readBody reads the request body in full and replaces it with an
+// equivalent reader, so the request can still be sent or handled. The
+// request's ContentLength and GetBody are set to match the buffered body,
+// so the request can be retried or redirected; its context is untouched.
+// If the body is longer than limit, ErrBodyTooLarge is returned and the
+// body is left readable from the start.
+func readBody(r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		r.Body.Close()
+		return nil, err
+	}
+
+	if int64(len(body)) > limit {
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, ErrBodyTooLarge
+	}
+
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, nil
+}
+
+// readGetBody reads a fresh copy of the request body from r.GetBody,
+// leaving r.Body untouched.
+func readGetBody(r *http.Request, limit int64) ([]byte, error) {
+	rc, err := r.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+
+	return body, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
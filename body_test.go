@@ -0,0 +1,271 @@
+package apiauth
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetDigest(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader([]byte(`post body`)))
+	require.NoError(t, SetDigest(req))
+	require.Equal(t, "SHA-256=j09Me7AD7qb95Sewlsn+2f9ssSCscNM+UIyDArFYqPk=", req.Header.Get("Digest"))
+
+	body, err := ioutil.ReadAll(req.Body)
+	require.NoError(t, err)
+	require.Equal(t, "post body", string(body))
+}
+
+func TestVerifyDigest(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader([]byte(`post body`)))
+	require.Error(t, VerifyDigest(req))
+
+	req.Header.Set("Digest", "MD5=WnNni3tnQAUFZDSkgFRwfQ==")
+	require.Error(t, VerifyDigest(req))
+
+	req.Header.Set("Digest", "MD5=WnNni3tnQAUFZDSkgFRwfQ==, sha-256=j09Me7AD7qb95Sewlsn+2f9ssSCscNM+UIyDArFYqPk=")
+	require.NoError(t, VerifyDigest(req))
+
+	req.Body = ioutil.NopCloser(bytes.NewReader([]byte(`other body`)))
+	require.EqualError(t, VerifyDigest(req), "Digest mismatch")
+}
+
+func TestSign_Digest(t *testing.T) {
+	body := []byte(`post body`)
+	req, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader(body))
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-MD5", base64md5(body))
+
+	c := Canonicalizer{IntegrityHeader: DigestHeader}
+	s := Signer{AccessID: "me", Secret: "secret", Canonicalizer: c}
+	require.EqualError(t, s.Sign(req), "No Digest header present")
+
+	require.NoError(t, SetDigest(req))
+	require.NoError(t, s.Sign(req))
+	require.Equal(t, "text/plain,SHA-256=j09Me7AD7qb95Sewlsn+2f9ssSCscNM+UIyDArFYqPk=,/,Fri, 20 Mar 2015 19:37:40 GMT", c.CanonicalString(req))
+
+	v := Verifier{Secret: "secret", Canonicalizer: c}
+	require.NoError(t, v.Verify(req))
+	require.NoError(t, VerifyDigest(req))
+	require.Error(t, Verify(req, "secret"))
+}
+
+func TestContentMD5(t *testing.T) {
+	body := []byte(`post body`)
+	req, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader(body))
+	require.NoError(t, SetContentMD5(req))
+	require.Equal(t, base64md5(body), req.Header.Get("Content-MD5"))
+	require.NoError(t, VerifyContentMD5(req))
+
+	req.Header.Set("Content-MD5", base64md5([]byte(`other body`)))
+	require.EqualError(t, VerifyContentMD5(req), "Content-MD5 mismatch")
+}
+
+func TestCheckContentMD5(t *testing.T) {
+	body := []byte(`post body`)
+	req, _ := http.NewRequest("POST", "http://example.com", nil)
+	require.Equal(t, ErrMissingContentMD5, CheckContentMD5(req, body))
+
+	req.Header.Set("Content-MD5", base64md5(body))
+	require.NoError(t, CheckContentMD5(req, body))
+	require.Equal(t, ErrContentMD5Mismatch, CheckContentMD5(req, []byte(`other body`)))
+
+	// The comparison is exact: the same digest in another encoding is
+	// not accepted.
+	req.Header.Set("Content-MD5", strings.ToLower(base64md5(body)))
+	require.Equal(t, ErrContentMD5Mismatch, CheckContentMD5(req, body))
+}
+
+func TestMaxBodySize(t *testing.T) {
+	body := []byte(`post body`)
+	req, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader(body))
+
+	s := Signer{AccessID: "me", Secret: "secret", MaxBodySize: 4}
+	require.Equal(t, ErrBodyTooLarge, s.SetContentMD5(req))
+	require.Equal(t, "", req.Header.Get("Content-MD5"))
+
+	read, err := ioutil.ReadAll(req.Body)
+	require.NoError(t, err)
+	require.Equal(t, body, read)
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.Header.Set("Content-MD5", base64md5(body))
+	v := Verifier{Secret: "secret", MaxBodySize: int64(len(body))}
+	require.NoError(t, v.VerifyContentMD5(req))
+
+	v.MaxBodySize--
+	require.Equal(t, ErrBodyTooLarge, v.VerifyContentMD5(req))
+}
+
+func TestEnsureContentMD5(t *testing.T) {
+	body := []byte(`post body`)
+	req, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader(body))
+	require.NotNil(t, req.GetBody)
+
+	require.NoError(t, EnsureContentMD5(req))
+	require.Equal(t, base64md5(body), req.Header.Get("Content-MD5"))
+	require.NoError(t, EnsureContentMD5(req))
+	require.Equal(t, base64md5(body), req.Header.Get("Content-MD5"))
+
+	read, err := ioutil.ReadAll(req.Body)
+	require.NoError(t, err)
+	require.Equal(t, body, read)
+
+	stale, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader([]byte(`new body`)))
+	stale.Header.Set("Content-MD5", base64md5(body))
+	require.EqualError(t, EnsureContentMD5(stale), "Content-MD5 mismatch")
+	require.Equal(t, base64md5(body), stale.Header.Get("Content-MD5"))
+}
+
+func TestEnsureContentMD5_NoGetBody(t *testing.T) {
+	body := []byte(`post body`)
+	req, _ := http.NewRequest("POST", "http://example.com", ioutil.NopCloser(bytes.NewReader(body)))
+	require.Nil(t, req.GetBody)
+
+	require.NoError(t, EnsureContentMD5(req))
+	require.Equal(t, base64md5(body), req.Header.Get("Content-MD5"))
+
+	read, err := ioutil.ReadAll(req.Body)
+	require.NoError(t, err)
+	require.Equal(t, body, read)
+}
+
+func TestSetContentMD5_PreservesRequest(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "value")
+
+	body := []byte(`post body`)
+	// A reader of unknown length, without GetBody.
+	req, _ := http.NewRequestWithContext(ctx, "POST", "http://example.com", ioutil.NopCloser(bytes.NewReader(body)))
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Content-Type", "text/plain")
+	require.Nil(t, req.GetBody)
+
+	require.NoError(t, SetContentMD5(req))
+	require.NoError(t, SignWithMethod(req, "me", "secret"))
+
+	require.Equal(t, "value", req.Context().Value(key{}))
+	require.Equal(t, int64(len(body)), req.ContentLength)
+
+	// GetBody returns the full body, however much of Body has been read.
+	got, err := ioutil.ReadAll(req.Body)
+	require.NoError(t, err)
+	require.Equal(t, body, got)
+
+	for i := 0; i < 2; i++ {
+		rc, err := req.GetBody()
+		require.NoError(t, err)
+		got, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		require.Equal(t, body, got)
+	}
+}
+
+func TestComputeMD5JSON(t *testing.T) {
+	want := base64md5([]byte(`{"a":[1,2.50,{"c":null,"d":"\u003cx\u003e"}],"b":true}`))
+	for _, body := range []string{
+		`{"a":[1,2.50,{"c":null,"d":"<x>"}],"b":true}`,
+		`{"b": true, "a": [1, 2.50, {"d": "<x>", "c": null}]}`,
+		"\n{\n\t\"b\" : true,\n\t\"a\" : [ 1, 2.50, { \"d\":\"\\u003cx>\", \"c\":null } ]\n}\n",
+	} {
+		got, err := ComputeMD5JSON([]byte(body))
+		require.NoError(t, err, body)
+		require.Equal(t, want, got, body)
+	}
+
+	// Numbers are kept as written.
+	one, _ := ComputeMD5JSON([]byte(`{"n":1}`))
+	oneDotZero, _ := ComputeMD5JSON([]byte(`{"n":1.0}`))
+	require.NotEqual(t, one, oneDotZero)
+
+	for _, body := range []string{``, `{`, `{"a":1} {"b":2}`, `not json`} {
+		_, err := ComputeMD5JSON([]byte(body))
+		require.Equal(t, ErrInvalidJSON, err, body)
+	}
+}
+
+func TestJSONContentMD5(t *testing.T) {
+	s := Signer{JSONContentMD5: true}
+	v := Verifier{JSONContentMD5: true}
+
+	req, _ := http.NewRequest("POST", "http://example.com", strings.NewReader(`{"b": 2, "a": 1}`))
+	require.NoError(t, s.SetContentMD5(req))
+	require.Equal(t, base64md5([]byte(`{"a":1,"b":2}`)), req.Header.Get("Content-MD5"))
+	require.NoError(t, v.VerifyContentMD5(req))
+
+	// A proxy re-serializing the body does not break it.
+	req.Body = ioutil.NopCloser(strings.NewReader(`{ "a": 1, "b": 2 }`))
+	require.NoError(t, v.VerifyContentMD5(req))
+	require.Equal(t, ErrContentMD5Mismatch, VerifyContentMD5(req))
+
+	req.Body = ioutil.NopCloser(strings.NewReader(`{"a":1,"b":3}`))
+	require.Equal(t, ErrContentMD5Mismatch, v.VerifyContentMD5(req))
+
+	req.Body = ioutil.NopCloser(strings.NewReader(`a=1&b=2`))
+	require.Equal(t, ErrInvalidJSON, v.VerifyContentMD5(req))
+	require.Equal(t, ErrInvalidJSON, s.SetContentMD5(req))
+}
+
+func TestDecodedContentMD5(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte("hello"))
+	zw.Close()
+
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequest("POST", "http://example.com/", bytes.NewReader(compressed.Bytes()))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Content-Encoding", "gzip")
+		return req
+	}
+
+	// By default, the Content-MD5 covers the compressed body.
+	req := newRequest()
+	require.NoError(t, SetContentMD5(req))
+	require.Equal(t, contentMD5(compressed.Bytes()), req.Header.Get("Content-MD5"))
+	require.NoError(t, VerifyContentMD5(req))
+
+	decoded := Verifier{DecodedContentMD5: true}
+	require.Equal(t, ErrContentMD5Mismatch, decoded.VerifyContentMD5(req))
+
+	// With DecodedContentMD5, it covers the decompressed body.
+	req = newRequest()
+	s := Signer{DecodedContentMD5: true}
+	require.NoError(t, s.SetContentMD5(req))
+	require.Equal(t, "XUFAKrxLKna5cZ2REBfFkg==", req.Header.Get("Content-MD5"))
+	require.NoError(t, decoded.VerifyContentMD5(req))
+	require.Equal(t, ErrContentMD5Mismatch, VerifyContentMD5(req))
+
+	// The body is left compressed for the handler.
+	body, _ := ioutil.ReadAll(req.Body)
+	require.Equal(t, compressed.Bytes(), body)
+
+	// The limit applies to the decompressed body too.
+	var bomb bytes.Buffer
+	zw = gzip.NewWriter(&bomb)
+	zw.Write(bytes.Repeat([]byte("a"), 1<<16))
+	zw.Close()
+
+	limited := Verifier{DecodedContentMD5: true, MaxBodySize: 1 << 10}
+	req, _ = http.NewRequest("POST", "http://example.com/", bytes.NewReader(bomb.Bytes()))
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Content-MD5", "XUFAKrxLKna5cZ2REBfFkg==")
+	require.Less(t, bomb.Len(), 1<<10)
+	require.Equal(t, ErrBodyTooLarge, limited.VerifyContentMD5(req))
+
+	req = newRequest()
+	req.Header.Set("Content-MD5", "XUFAKrxLKna5cZ2REBfFkg==")
+	req.Header.Set("Content-Encoding", "br")
+	require.Equal(t, ErrUnsupportedContentEncoding, decoded.VerifyContentMD5(req))
+
+	req, _ = http.NewRequest("POST", "http://example.com/", strings.NewReader("hello"))
+	req.Header.Set("Content-MD5", "XUFAKrxLKna5cZ2REBfFkg==")
+	require.NoError(t, decoded.VerifyContentMD5(req))
+}
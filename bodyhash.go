@@ -0,0 +1,95 @@
+package apiauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// BodyHash returns the lowercase hex SHA-256 of a body, as signed by
+// SignWithBodyHash.
+func BodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// CanonicalStringWithBodyHash returns the canonical string signed by
+// SignWithBodyHash: the Content-Type, URI and Date, as in CanonicalString
+// but without Content-MD5, followed by the body hash, such as BodyHash
+// returns, in place of it:
+//
+//	Content-Type,URI,Date,body-hash
+//
+// The body hash is lowercased. Requests without a body are signed with
+// the hash of an empty body, BodyHash(nil).
+func CanonicalStringWithBodyHash(r *http.Request, bodyHashHex string) string {
+	return bodyHashBuilder{strings.ToLower(bodyHashHex)}.CanonicalString(r)
+}
+
+// SignWithBodyHash signs a request as Sign does, but with the
+// canonical string of CanonicalStringWithBodyHash, which covers the body
+// through bodyHashHex, a hex SHA-256 computed by the caller, in place of
+// the Content-MD5 header, which is not required. The Date header, and the
+// Content-Type for requests with a body, are still required.
+func SignWithBodyHash(r *http.Request, accessID, secret, bodyHashHex string) error {
+	unbodied, err := withBodyHash(r, bodyHashHex)
+	if err != nil {
+		return err
+	}
+
+	s := Signer{AccessID: accessID, Secret: secret, Builder: bodyHashBuilder{strings.ToLower(bodyHashHex)}}
+	return s.Sign(unbodied)
+}
+
+// VerifyWithBodyHash checks a request signed with SignWithBodyHash, as
+// Verify does, given the hex SHA-256 of the body as received, which
+// callers or middleware must compute from the body, such as with
+// BodyHash. Signatures with and without the method are accepted.
+func VerifyWithBodyHash(r *http.Request, secret, bodyHashHex string) error {
+	unbodied, err := withBodyHash(r, bodyHashHex)
+	if err != nil {
+		return err
+	}
+
+	v := Verifier{Secret: secret, Builder: bodyHashBuilder{strings.ToLower(bodyHashHex)}}
+	return v.Verify(unbodied)
+}
+
+// withBodyHash checks the body hash and the Content-Type of a request
+// signed with it, and returns a copy of the request without its body, so
+// that the Content-MD5 header is not required. The copy shares the
+// request's headers.
+func withBodyHash(r *http.Request, bodyHashHex string) (*http.Request, error) {
+	if err := checkRequest(r); err != nil {
+		return nil, err
+	}
+
+	if _, err := hex.DecodeString(bodyHashHex); err != nil || len(bodyHashHex) != 2*sha256.Size {
+		return nil, fmt.Errorf("apiauth: invalid body hash %q", bodyHashHex)
+	}
+
+	if r.Body != nil && r.Body != http.NoBody && r.Header.Get("Content-Type") == "" {
+		return nil, ErrMissingContentType
+	}
+
+	unbodied := *r
+	unbodied.Body = nil
+	return &unbodied, nil
+}
+
+// bodyHashBuilder builds the canonical string of
+// CanonicalStringWithBodyHash.
+type bodyHashBuilder struct {
+	hash string
+}
+
+func (b bodyHashBuilder) CanonicalString(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+
+	c := Canonicalizer{Fields: []CanonicalField{FieldContentType, FieldURI, FieldDate}}
+	return c.CanonicalString(r) + "," + b.hash
+}
@@ -0,0 +1,34 @@
+package apiauth
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBodyHash(t *testing.T) {
+	require.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", BodyHash(nil))
+
+	body := []byte(`post body`)
+	req, _ := http.NewRequest("POST", "http://example.com/some/path?x=1", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+
+	hash := BodyHash(body)
+	require.Equal(t, "text/plain,/some/path?x=1,Fri, 20 Mar 2015 19:37:40 GMT,"+hash, CanonicalStringWithBodyHash(req, hash))
+	require.Equal(t, "", CanonicalStringWithBodyHash(nil, hash))
+
+	require.NoError(t, SignWithBodyHash(req, "me", "secret", hash))
+	require.Empty(t, req.Header.Get("Content-MD5"))
+	require.Equal(t, "APIAuth me:"+Compute(CanonicalStringWithBodyHash(req, hash), "secret"), req.Header.Get("Authorization"))
+
+	require.NoError(t, VerifyWithBodyHash(req, "secret", hash))
+	require.Equal(t, ErrSignatureMismatch, VerifyWithBodyHash(req, "secret", BodyHash([]byte(`tampered`))))
+	require.Equal(t, ErrMissingContentMD5, Verify(req, "secret"))
+	require.Error(t, VerifyWithBodyHash(req, "secret", "not hex"))
+
+	req.Header.Del("Content-Type")
+	require.Equal(t, ErrMissingContentType, VerifyWithBodyHash(req, "secret", hash))
+}
@@ -0,0 +1,30 @@
+package apiauth
+
+// NewBrowserVerifier returns a Verifier for requests signed by browser
+// clients with the Web Crypto API, which can compute HMAC-SHA256 and
+// SHA-256 but not MD5, and which cannot set the Date header. Requests
+// must use the V2 scheme with HMAC-SHA256, carrying the signing time in
+// the Authorization header, and a body's checksum is an RFC 3230 Digest
+// header in place of Content-MD5. The timestamp is checked against
+// DefaultMaxPast. Secrets are looked up with keyFunc.
+//
+// A browser signs a request by computing, in JavaScript:
+//
+//	const digest = "SHA-256=" + base64(await crypto.subtle.digest("SHA-256", body));
+//	const ts = Math.floor(Date.now() / 1000).toString();
+//	const canonical = [method.toUpperCase(), contentType, digest, pathAndQuery, ts].join(",");
+//	const key = await crypto.subtle.importKey("raw", utf8(secret), {name: "HMAC", hash: "SHA-256"}, false, ["sign"]);
+//	const sig = base64(await crypto.subtle.sign("HMAC", key, utf8(canonical)));
+//
+// and sending the headers `Digest: <digest>` and `Authorization:
+// APIAuth-HMAC-SHA256 <access ID>:<ts>:<sig>`. Requests without a body
+// leave contentType and digest empty. The Verifier may be adjusted
+// further before use.
+func NewBrowserVerifier(keyFunc KeyFunc) *Verifier {
+	return &Verifier{
+		KeyFunc:       keyFunc,
+		MaxPast:       DefaultMaxPast,
+		RequireV2:     true,
+		Canonicalizer: Canonicalizer{IntegrityHeader: DigestHeader},
+	}
+}
@@ -0,0 +1,48 @@
+package apiauth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBrowserVerifier(t *testing.T) {
+	// Signed as the browser code in NewBrowserVerifier's documentation
+	// would sign it, at 1426880260.
+	const canonical = "POST,application/json,SHA-256=JW4rNhldbJ0lt4vw33ABnLYEIbCIz5bKIeVw+/w09rI=,/api/items?draft=1,1426880260"
+	const auth = "APIAuth-HMAC-SHA256 me:1426880260:xK9H+bcaKqdkCLu95K+j+JklTZnmN6qBi0NYtJH8ZTs="
+
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequest("POST", "http://example.com/api/items?draft=1", strings.NewReader(`{"name":"widget"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(DigestHeader, "SHA-256=JW4rNhldbJ0lt4vw33ABnLYEIbCIz5bKIeVw+/w09rI=")
+		req.Header.Set("Authorization", auth)
+		return req
+	}
+
+	v := NewBrowserVerifier(v2Keys)
+	v.Now = func() time.Time { return time.Unix(1426880260, 0).Add(time.Minute) }
+
+	req := newRequest()
+	require.Equal(t, canonical, canonicalStringV2(v.builder(req), req, v.dateHeader(), "1426880260"))
+	require.NoError(t, v.Verify(req))
+	require.NoError(t, v.VerifyWithBodyReader(req))
+
+	req, _ = http.NewRequest("POST", "http://example.com/api/items?draft=1", strings.NewReader(`{"name":"gadget"}`))
+	req.Header = newRequest().Header
+	require.Equal(t, ErrDigestMismatch, v.VerifyWithBodyReader(req))
+
+	// The original scheme is rejected.
+	req = newRequest()
+	req.Header.Del("Authorization")
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:38:40 GMT")
+	s := Signer{AccessID: "me", Secret: "secret", Canonicalizer: v.Canonicalizer}
+	require.NoError(t, s.Sign(req))
+	require.Equal(t, ErrMalformedHeader, v.Verify(req))
+
+	v.Now = nil
+	require.Equal(t, ErrDateTooOld, v.Verify(newRequest()))
+}
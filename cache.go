@@ -0,0 +1,159 @@
+package apiauth
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// CachingKeyFunc returns a key function that memoizes the secrets
+// returned by inner for ttl, holding at most maxEntries of them and
+// evicting the least recently used first. Errors from inner are returned
+// and not cached. A ttl or maxEntries of zero or less means no limit.
+// The returned function is safe for concurrent use; inner is not called
+// with the cache locked, and concurrent misses for one access ID share a
+// single call.
+func CachingKeyFunc(inner func(id string) (string, error), ttl time.Duration, maxEntries int) func(string) (string, error) {
+	return newKeyCache(inner, ttl, 0, maxEntries).get
+}
+
+func newKeyCache(inner func(id string) (string, error), ttl, negativeTTL time.Duration, maxEntries int) *keyCache {
+	return &keyCache{
+		inner:       inner,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+		now:         time.Now,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+	}
+}
+
+type keyCache struct {
+	inner      func(id string) (string, error)
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	// negativeTTL, if positive, is how long ErrUnknownAccessID is
+	// cached for.
+	negativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+	calls   map[string]*keyCall
+}
+
+type keyCacheEntry struct {
+	id      string
+	secret  string
+	err     error
+	expires time.Time
+}
+
+// A keyCall is a call to inner in progress, which concurrent misses for
+// the same access ID wait for.
+type keyCall struct {
+	done   chan struct{}
+	secret string
+	err    error
+}
+
+func (c *keyCache) get(id string) (string, error) {
+	c.mu.Lock()
+	if entry, ok := c.lookup(id); ok {
+		c.mu.Unlock()
+		return entry.secret, entry.err
+	}
+
+	if call, ok := c.calls[id]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.secret, call.err
+	}
+
+	if c.calls == nil {
+		c.calls = make(map[string]*keyCall)
+	}
+	call := &keyCall{done: make(chan struct{})}
+	c.calls[id] = call
+	c.mu.Unlock()
+
+	returned := false
+	defer func() {
+		if !returned {
+			// inner panicked; fail any waiters rather than hand
+			// them an empty secret.
+			call.err = errKeyFuncPanicked
+		}
+
+		c.mu.Lock()
+		delete(c.calls, id)
+		switch {
+		case !returned:
+		case call.err == nil:
+			c.store(id, call.secret, nil, c.ttl)
+		case c.negativeTTL > 0 && errors.Is(call.err, ErrUnknownAccessID):
+			c.store(id, "", call.err, c.negativeTTL)
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.secret, call.err = c.inner(id)
+	returned = true
+	return call.secret, call.err
+}
+
+var errKeyFuncPanicked = errors.New("apiauth: key function panicked")
+
+// lookup returns the unexpired entry for the access ID. The cache must be
+// locked.
+func (c *keyCache) lookup(id string) (*keyCacheEntry, bool) {
+	elem, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*keyCacheEntry)
+	if c.expired(entry) {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry, true
+}
+
+// store caches a secret, or an error, for ttl. The cache must be locked.
+func (c *keyCache) store(id, secret string, err error, ttl time.Duration) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = c.now().Add(ttl)
+	}
+
+	if elem, ok := c.entries[id]; ok {
+		c.remove(elem)
+	}
+	c.entries[id] = c.order.PushFront(&keyCacheEntry{id, secret, err, expires})
+
+	// Evict least recently used entries that have expired or are over
+	// the limit.
+	for back := c.order.Back(); back != nil; back = c.order.Back() {
+		if !c.expired(back.Value.(*keyCacheEntry)) && (c.maxEntries <= 0 || c.order.Len() <= c.maxEntries) {
+			break
+		}
+		c.remove(back)
+	}
+}
+
+func (c *keyCache) expired(entry *keyCacheEntry) bool {
+	return !entry.expires.IsZero() && !c.now().Before(entry.expires)
+}
+
+func (c *keyCache) remove(elem *list.Element) {
+	delete(c.entries, elem.Value.(*keyCacheEntry).id)
+	c.order.Remove(elem)
+}
@@ -0,0 +1,143 @@
+package apiauth
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKeyCache(ttl time.Duration, maxEntries int) (*keyCache, map[string]int, *time.Time) {
+	calls := make(map[string]int)
+	now := time.Date(2015, time.March, 20, 19, 37, 40, 0, time.UTC)
+	c := &keyCache{
+		inner: func(id string) (string, error) {
+			calls[id]++
+			if id == "unknown" {
+				return "", errUnknownKey
+			}
+			return "secret for " + id, nil
+		},
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        func() time.Time { return now },
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+	return c, calls, &now
+}
+
+func TestKeyCache_TTL(t *testing.T) {
+	c, calls, now := newTestKeyCache(time.Minute, 0)
+
+	for i := 0; i < 3; i++ {
+		secret, err := c.get("me")
+		require.NoError(t, err)
+		require.Equal(t, "secret for me", secret)
+	}
+	require.Equal(t, 1, calls["me"])
+
+	*now = now.Add(time.Minute - time.Second)
+	c.get("me")
+	require.Equal(t, 1, calls["me"])
+
+	*now = now.Add(time.Second)
+	c.get("me")
+	require.Equal(t, 2, calls["me"])
+
+	// Errors are not cached.
+	for i := 0; i < 2; i++ {
+		_, err := c.get("unknown")
+		require.Equal(t, errUnknownKey, err)
+	}
+	require.Equal(t, 2, calls["unknown"])
+
+	// Expired entries are evicted when others are stored.
+	c.get("other")
+	*now = now.Add(time.Hour)
+	c.get("another")
+	require.Equal(t, 1, c.order.Len())
+	require.Len(t, c.entries, 1)
+}
+
+func TestKeyCache_MaxEntries(t *testing.T) {
+	c, calls, _ := newTestKeyCache(0, 2)
+
+	c.get("a")
+	c.get("b")
+	c.get("a") // b is now least recently used
+	c.get("c")
+	require.Equal(t, 2, c.order.Len())
+
+	c.get("a")
+	c.get("c")
+	require.Equal(t, 1, calls["a"])
+	require.Equal(t, 1, calls["c"])
+
+	c.get("b")
+	require.Equal(t, 2, calls["b"])
+	require.Equal(t, 2, c.order.Len())
+}
+
+func TestCachingKeyFunc(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	keyFunc := CachingKeyFunc(func(id string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return "secret", nil
+	}, time.Hour, 10)
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+
+	require.NoError(t, VerifyWithKeyFunc(req, keyFunc))
+	require.NoError(t, VerifyWithKeyFunc(req, keyFunc))
+	require.Equal(t, 1, calls)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			keyFunc(fmt.Sprint(i % 20))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestKeyCache_NegativeTTL(t *testing.T) {
+	calls := 0
+	now := time.Date(2015, time.March, 20, 19, 37, 40, 0, time.UTC)
+	c := newKeyCache(func(id string) (string, error) {
+		calls++
+		return "", ErrUnknownAccessID
+	}, time.Hour, time.Minute, 0)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, err := c.get("unknown")
+		require.Equal(t, ErrUnknownAccessID, err)
+	}
+	require.Equal(t, 1, calls)
+
+	now = now.Add(time.Minute)
+	c.get("unknown")
+	require.Equal(t, 2, calls)
+}
+
+func TestKeyCache_Panic(t *testing.T) {
+	c := newKeyCache(func(id string) (string, error) {
+		panic("boom")
+	}, time.Hour, time.Minute, 0)
+
+	require.Panics(t, func() { c.get("me") })
+	require.Empty(t, c.entries)
+	require.Empty(t, c.calls)
+}
@@ -0,0 +1,810 @@
+package apiauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// A CanonicalBuilder builds the canonical string that is signed for a
+// request. Signers and Verifiers use a Canonicalizer unless given another
+// builder, and the client and server must always use equivalent builders.
+type CanonicalBuilder interface {
+	CanonicalString(r *http.Request) string
+}
+
+// DefaultBuilder builds canonical strings exactly as CanonicalString does.
+var DefaultBuilder CanonicalBuilder = Canonicalizer{}
+
+// A CanonicalField computes one component of a canonical string. The
+// components are joined with commas, in the order of a Canonicalizer's
+// Fields.
+type CanonicalField func(c Canonicalizer, r *http.Request) string
+
+// The fields from which canonical strings are built.
+var (
+	// FieldMethod is the uppercased request method, or its override;
+	// see MethodOverrideHeader.
+	FieldMethod CanonicalField = Canonicalizer.method
+
+	// FieldEffectiveMethod is the method as FieldMethod gives it, but
+	// with an empty method, which net/http sends as GET, signed as GET.
+	FieldEffectiveMethod CanonicalField = Canonicalizer.effectiveMethod
+
+	// FieldContentType is the Content-Type header.
+	FieldContentType CanonicalField = func(c Canonicalizer, r *http.Request) string {
+		return r.Header.Get("Content-Type")
+	}
+
+	// FieldIntegrity is the body checksum header named by IntegrityHeader,
+	// normalized if NormalizeContentMD5 is set.
+	FieldIntegrity CanonicalField = Canonicalizer.integrityValue
+
+	// FieldHost is the lowercased request host; see IncludeHost.
+	FieldHost CanonicalField = Canonicalizer.host
+
+	// FieldURI is the escaped path and the raw query, if any. An empty
+	// path is always treated as `/`, so `http://example.com?x=1` gives
+	// `/?x=1` whether or not the client added the slash. With FullURL,
+	// the scheme and host precede the path.
+	FieldURI CanonicalField = Canonicalizer.uri
+
+	// FieldPath is the escaped path, defaulting to `/`, without the
+	// query. Any query a request carries is then not authenticated.
+	FieldPath CanonicalField = Canonicalizer.path
+
+	// FieldDate is the Date header, or the header named by DateHeader
+	// or ExpiresHeader.
+	FieldDate CanonicalField = Canonicalizer.date
+
+	// FieldClientCertificate is the SHA-256 fingerprint of the TLS
+	// client certificate; see BindClientCertificate.
+	FieldClientCertificate CanonicalField = Canonicalizer.clientCertificate
+
+	// FieldSignedHeaders is each of the SignedHeaders, then each of the
+	// OptionalSignedHeaders, as `Name:value`.
+	FieldSignedHeaders CanonicalField = Canonicalizer.signedHeaders
+
+	// FieldAAD is the AAD, base64 encoded.
+	FieldAAD CanonicalField = Canonicalizer.aad
+)
+
+// A Canonicalizer is the standard CanonicalBuilder. Its zero value
+// produces exactly the output of CanonicalString and
+// CanonicalStringWithMethod. Any other setting changes the resulting
+// signature, so clients and servers must be configured identically.
+type Canonicalizer struct {
+	// Fields, if set, replaces the default layout of the canonical
+	// string: the content type, integrity header, host (if IncludeHost
+	// is set), URI, date, client certificate (if BindClientCertificate
+	// is set), signed headers (if any) and AAD (if any), in that order.
+	// IncludeHost, BindClientCertificate, SignedHeaders and AAD then
+	// only affect the string through FieldHost, FieldClientCertificate,
+	// FieldSignedHeaders and FieldAAD, though what they name is still
+	// required to be present.
+	Fields []CanonicalField
+
+	// IntegrityHeader names the header carrying the body checksum,
+	// which is required when a body is present and is included in
+	// the canonical string. It defaults to Content-MD5; set it to
+	// DigestHeader to use RFC 3230 digests instead.
+	IntegrityHeader string
+
+	// OptionalContentType lets requests with a body omit the
+	// Content-Type header, which is then signed as empty; the integrity
+	// header is still required. It is for clients that cannot send the
+	// header, and is weaker: a request without one leaves the server to
+	// guess how to interpret its body, and the guess is not covered by
+	// the signature. It only changes which requests are accepted, so
+	// clients and servers need only enable it where needed.
+	OptionalContentType bool
+
+	// NormalizeContentMD5 rewrites the Content-MD5 value to standard,
+	// padded base64 before it is included in the canonical string, so
+	// clients that send it unpadded, URL-safe or hex-encoded still
+	// agree with servers that do not. Values that cannot be decoded as
+	// an MD5 are used unchanged. Both ends must enable it.
+	NormalizeContentMD5 bool
+
+	// NormalizeQuery rewrites each `+` in the raw query to `%20` before
+	// it is included in the canonical string. Both encode a space, and
+	// the query is otherwise signed exactly as sent, so a client that
+	// sends `q=a+b` would not match a server or proxy that re-encodes
+	// the query with url.Values as `q=a%20b`. A literal plus is always
+	// sent as `%2B`, and is unaffected. Both ends must enable it.
+	NormalizeQuery bool
+
+	// SortQuery sorts the query parameters before the query is included
+	// in the canonical string, so that clients and servers, or proxies,
+	// that reorder them still agree. Parameters are sorted by key, then
+	// by value, comparing their escaped forms as sent (after
+	// NormalizeQuery) byte by byte, so that repeated keys such as
+	// `a=2&a=1` sign as `a=1&a=2`. Empty parameters, as in `a=1&&b=2`,
+	// are dropped. Both ends must enable it.
+	SortQuery bool
+
+	// TrailingSlash normalizes a trailing slash on the path before it is
+	// included in the canonical string, so that `/resource` and
+	// `/resource/` sign alike when clients and routers disagree about
+	// it. Both ends must set it the same way.
+	TrailingSlash TrailingSlash
+
+	// RFC3986Path re-encodes the path strictly as RFC 3986 does before
+	// it is included in the canonical string: every byte but the
+	// unreserved characters (letters, digits, `-`, `.`, `_` and `~`) is
+	// percent-encoded in uppercase hex in each segment, including the
+	// sub-delimiters, such as `+`, `=` and `:`, that Go leaves alone. It
+	// matches peers that encode paths that way, such as those following
+	// AWS Signature Version 4. Both ends must enable it.
+	RFC3986Path bool
+
+	// MethodOverrideHeader, if set, names a header (typically
+	// X-HTTP-Method-Override) whose value, when present, is signed as the
+	// request method in place of r.Method, for clients that tunnel other
+	// methods through POST. It binds the method the application acts on
+	// to the signature, but only in canonical strings that include the
+	// method, so servers should also only accept those (see Builders).
+	MethodOverrideHeader string
+
+	// DateHeader, if set, names a header (such as X-Client-Date) that
+	// carries the signing date in place of Date, which is then ignored
+	// entirely: the named header is required, is signed in place of
+	// Date, and is the one checked against a Verifier's MaxPast and
+	// MaxFuture. It is for servers behind caches or CDNs that may
+	// overwrite the Date header, or clients that echo back a cached one.
+	// Both ends must set it the same way.
+	DateHeader string
+
+	// ExpiresHeader, if set, names a header (such as X-Expires) carrying
+	// the time the signature expires, in seconds since the Unix epoch,
+	// which is signed in place of the Date, for presigned requests that
+	// are valid until a deadline rather than for a window after they
+	// were signed. The named header is required and the Date, or
+	// DateHeader, is ignored entirely. Verifiers reject requests whose
+	// expiry has passed with ErrExpired, in place of checking MaxPast,
+	// MaxFuture, RejectBefore and SameUTCDay; how far ahead the expiry
+	// may be is up to the signer. It cannot be combined with
+	// SigningKey. Both ends must set it the same way.
+	ExpiresHeader string
+
+	// SignedHeaders lists additional headers to include in the
+	// canonical string, each serialized as `Name:value` after the
+	// Date. Every listed header must be present when signing or
+	// verifying, or ErrMissingSignedHeader is returned. For example,
+	// listing Accept stops a signature from being replayed to request
+	// a different representation.
+	SignedHeaders []string
+
+	// OptionalSignedHeaders lists headers to include in the canonical
+	// string as SignedHeaders are, after them, but which may be absent:
+	// an absent header is signed with an empty value. A header sent
+	// with a value cannot be stripped or altered in transit, but one
+	// sent empty is indistinguishable from one not sent. It suits
+	// headers such as Prefer that only some requests carry.
+	OptionalSignedHeaders []string
+
+	// LowercaseHeaderNames serializes the names of SignedHeaders in
+	// lowercase, as `name:value`, in place of their canonical form, to
+	// match implementations that lowercase them.
+	LowercaseHeaderNames bool
+
+	// IncludeHost includes the request host, lowercased, in the
+	// canonical string just before the URI, binding the signature to
+	// the host the client sent it to. The host is taken from r.Host,
+	// falling back to r.URL.Host, so requests built with a relative
+	// URL must set r.Host; if neither is set, signing and verifying
+	// fail with ErrMissingHost.
+	IncludeHost bool
+
+	// FullURL signs the request URI as the full URL, such as
+	// `https://example.com/some/path?x=1`, binding the signature to the
+	// scheme and host as well as the path, so that a signed webhook
+	// callback cannot be replayed against another URL. The host is
+	// found as for IncludeHost and lowercased, and is required. Servers
+	// see relative URLs, so the scheme is taken from r.URL, then
+	// Scheme, then r.TLS: `https` for a TLS connection and `http`
+	// otherwise. Servers behind a proxy that terminates TLS must set
+	// Scheme, and should set Host. Both ends must enable it.
+	FullURL bool
+
+	// Scheme, if set, is the scheme signed with FullURL for requests
+	// whose URL does not carry one, as on servers.
+	Scheme string
+
+	// Host, if set, is used as the request host in place of r.Host and
+	// ForwardedHostHeader, for servers that know the host they are
+	// reached at. It applies to FullURL and IncludeHost.
+	Host string
+
+	// BindClientCertificate includes the SHA-256 fingerprint of the TLS
+	// client certificate, in lowercase hex, in the canonical string just
+	// after the date, binding the signature to the certificate, so that
+	// a stolen secret cannot be used by a client without its private
+	// key. It is only for mutual TLS endpoints: servers must be
+	// configured to request client certificates, and requests without
+	// one fail with ErrMissingClientCertificate. Servers read the
+	// certificate from r.TLS; clients must set their Signer's
+	// ClientCertificate. Servers behind a proxy that terminates TLS
+	// cannot use it. Both ends must enable it.
+	BindClientCertificate bool
+
+	// ForwardedHostHeader, if set, names a header (typically
+	// X-Forwarded-Host) whose first value is used as the host in place
+	// of r.Host. It is only honored for requests whose RemoteAddr is in
+	// TrustedProxies: anyone can send the header, so trusting it from
+	// arbitrary clients would let them choose the host that is checked.
+	// Only configure it behind proxies that overwrite the header.
+	ForwardedHostHeader string
+
+	// TrustedProxies lists the networks of the proxies allowed to set
+	// ForwardedHostHeader.
+	TrustedProxies []*net.IPNet
+
+	// AAD, if not empty, is additional authenticated data appended,
+	// base64 encoded, to the end of the canonical string, binding the
+	// signature to a value both ends know out of band, such as a
+	// session ID the server has already authenticated, without sending
+	// it in a header. It is per request, so set it on a copy of a shared
+	// Signer or Verifier, or use SignWithAAD and VerifyWithAAD. Both
+	// ends must supply the same AAD.
+	AAD []byte
+}
+
+// A CanonicalComponent is one named component of a canonical string.
+type CanonicalComponent struct {
+	// Name is one of Method, ContentType, ContentMD5, Host, URI, Date,
+	// ClientCertificate, SignedHeader or AAD, or the IntegrityHeader in
+	// place of ContentMD5 when it is set to another header, or Expires in
+	// place of Date when ExpiresHeader is set. It is empty for components
+	// built by a Canonicalizer's custom Fields.
+	Name string
+
+	Value string
+}
+
+// A TrailingSlash is how a Canonicalizer normalizes a trailing slash on
+// the request path.
+type TrailingSlash int
+
+const (
+	// KeepTrailingSlash signs the path as it is. It is the default.
+	KeepTrailingSlash TrailingSlash = iota
+
+	// StripTrailingSlash removes a single trailing slash from any path
+	// but `/`.
+	StripTrailingSlash
+
+	// AddTrailingSlash appends a slash to any path not ending in one.
+	AddTrailingSlash
+)
+
+// CanonicalString returns the canonical string used for the signature
+// based on the headers in the given request. A nil request has an empty
+// canonical string.
+func (c Canonicalizer) CanonicalString(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+
+	fields := c.Fields
+	if fields == nil {
+		fields = c.defaultFields()
+	}
+
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		values[i] = field(c, r)
+	}
+
+	return strings.Join(values, ",")
+}
+
+// CanonicalStringWithMethod returns a canonical string as in CanonicalString
+// but also includes the request method.
+func (c Canonicalizer) CanonicalStringWithMethod(r *http.Request) string {
+	return withMethod(c, r)
+}
+
+// CanonicalComponents returns the components of the canonical string
+// CanonicalString returns, in order, so that joining their values with
+// commas gives the canonical string. Each signed header is a
+// separate component. A nil request has no components.
+func (c Canonicalizer) CanonicalComponents(r *http.Request) []CanonicalComponent {
+	if r == nil {
+		return nil
+	}
+
+	if c.Fields != nil {
+		components := make([]CanonicalComponent, len(c.Fields))
+		for i, field := range c.Fields {
+			components[i] = CanonicalComponent{Value: field(c, r)}
+		}
+		return components
+	}
+
+	integrity := c.integrityHeader()
+	if http.CanonicalHeaderKey(integrity) == "Content-Md5" {
+		integrity = "ContentMD5"
+	}
+
+	components := []CanonicalComponent{
+		{"ContentType", FieldContentType(c, r)},
+		{integrity, FieldIntegrity(c, r)},
+	}
+	if c.IncludeHost {
+		components = append(components, CanonicalComponent{"Host", FieldHost(c, r)})
+	}
+
+	date := "Date"
+	if c.ExpiresHeader != "" {
+		date = "Expires"
+	}
+
+	components = append(components,
+		CanonicalComponent{"URI", FieldURI(c, r)},
+		CanonicalComponent{date, FieldDate(c, r)},
+	)
+	if c.BindClientCertificate {
+		components = append(components, CanonicalComponent{"ClientCertificate", FieldClientCertificate(c, r)})
+	}
+	for _, name := range c.allSignedHeaders() {
+		header := Canonicalizer{SignedHeaders: []string{name}, LowercaseHeaderNames: c.LowercaseHeaderNames}
+		components = append(components, CanonicalComponent{"SignedHeader", header.signedHeaders(r)})
+	}
+	if len(c.AAD) > 0 {
+		components = append(components, CanonicalComponent{"AAD", FieldAAD(c, r)})
+	}
+
+	return components
+}
+
+// CanonicalComponentsWithMethod returns the components of the canonical
+// string CanonicalStringWithMethod returns, as in CanonicalComponents.
+func (c Canonicalizer) CanonicalComponentsWithMethod(r *http.Request) []CanonicalComponent {
+	if r == nil {
+		return nil
+	}
+
+	method := CanonicalComponent{"Method", c.method(r)}
+	return append([]CanonicalComponent{method}, c.CanonicalComponents(r)...)
+}
+
+// MethodFirstFields returns the Fields of a Canonicalizer matching peer
+// implementations that sign the method as the first field of the
+// canonical string, as CanonicalStringWithMethod does, but sign an empty
+// method as GET:
+//
+//	METHOD,Content-Type,Content-MD5,URI,Date
+//
+// Signers using them must not set WithMethod.
+func MethodFirstFields() []CanonicalField {
+	return []CanonicalField{FieldEffectiveMethod, FieldContentType, FieldIntegrity, FieldURI, FieldDate}
+}
+
+func (c Canonicalizer) defaultFields() []CanonicalField {
+	fields := []CanonicalField{FieldContentType, FieldIntegrity}
+	if c.IncludeHost {
+		fields = append(fields, FieldHost)
+	}
+
+	fields = append(fields, FieldURI, FieldDate)
+	if c.BindClientCertificate {
+		fields = append(fields, FieldClientCertificate)
+	}
+	if len(c.SignedHeaders) > 0 || len(c.OptionalSignedHeaders) > 0 {
+		fields = append(fields, FieldSignedHeaders)
+	}
+	if len(c.AAD) > 0 {
+		fields = append(fields, FieldAAD)
+	}
+
+	return fields
+}
+
+// WithMethod returns a CanonicalBuilder that prefixes the canonical string
+// built by b with the request method, as CanonicalStringWithMethod does.
+func WithMethod(b CanonicalBuilder) CanonicalBuilder {
+	return methodBuilder{b}
+}
+
+type methodBuilder struct {
+	CanonicalBuilder
+}
+
+func (b methodBuilder) CanonicalString(r *http.Request) string {
+	return withMethod(b.CanonicalBuilder, r)
+}
+
+// withMethod prefixes the canonical string built by b with the request
+// method, as CanonicalStringWithMethod does.
+func withMethod(b CanonicalBuilder, r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+
+	method := strings.ToUpper(r.Method)
+	if c, ok := b.(Canonicalizer); ok {
+		method = c.method(r)
+	}
+
+	return method + "," + b.CanonicalString(r)
+}
+
+func (c Canonicalizer) method(r *http.Request) string {
+	if c.MethodOverrideHeader != "" {
+		if override := r.Header.Get(c.MethodOverrideHeader); override != "" {
+			return strings.ToUpper(override)
+		}
+	}
+	return strings.ToUpper(r.Method)
+}
+
+func (c Canonicalizer) effectiveMethod(r *http.Request) string {
+	if method := c.method(r); method != "" {
+		return method
+	}
+	return http.MethodGet
+}
+
+func (c Canonicalizer) path(r *http.Request) string {
+	var path string
+	if r.URL != nil {
+		path = r.URL.EscapedPath()
+	}
+	if path == "" {
+		path = "/"
+	}
+
+	if c.RFC3986Path {
+		path = rfc3986Encode(path)
+	}
+
+	switch c.TrailingSlash {
+	case StripTrailingSlash:
+		if len(path) > 1 && strings.HasSuffix(path, "/") {
+			path = path[:len(path)-1]
+		}
+	case AddTrailingSlash:
+		if !strings.HasSuffix(path, "/") {
+			path += "/"
+		}
+	}
+
+	return path
+}
+
+// rfc3986Encode re-encodes each segment of an escaped path, leaving only
+// the unreserved characters of RFC 3986 unescaped. Escaped slashes stay
+// escaped, and segments with invalid escapes are encoded as they are.
+func rfc3986Encode(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segment = unescaped
+		}
+
+		var b strings.Builder
+		for j := 0; j < len(segment); j++ {
+			ch := segment[j]
+			if 'A' <= ch && ch <= 'Z' || 'a' <= ch && ch <= 'z' || '0' <= ch && ch <= '9' ||
+				ch == '-' || ch == '.' || ch == '_' || ch == '~' {
+				b.WriteByte(ch)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", ch)
+			}
+		}
+		segments[i] = b.String()
+	}
+	return strings.Join(segments, "/")
+}
+
+func (c Canonicalizer) uri(r *http.Request) string {
+	uri := c.path(r)
+	if query := c.query(r); query != "" {
+		uri = uri + "?" + query
+	}
+
+	if c.FullURL {
+		uri = c.scheme(r) + "://" + c.host(r) + uri
+	}
+
+	return uri
+}
+
+func (c Canonicalizer) scheme(r *http.Request) string {
+	var scheme string
+	if r.URL != nil {
+		scheme = r.URL.Scheme
+	}
+
+	switch {
+	case scheme != "":
+	case c.Scheme != "":
+		scheme = c.Scheme
+	case r.TLS != nil:
+		scheme = "https"
+	default:
+		scheme = "http"
+	}
+
+	return strings.ToLower(scheme)
+}
+
+func (c Canonicalizer) query(r *http.Request) string {
+	if r.URL == nil {
+		return ""
+	}
+
+	query := r.URL.RawQuery
+	if c.NormalizeQuery {
+		query = strings.Replace(query, "+", "%20", -1)
+	}
+	if c.SortQuery {
+		query = sortQuery(query)
+	}
+	return query
+}
+
+// sortQuery sorts the parameters of a raw query by key, then by value.
+func sortQuery(query string) string {
+	type param struct{ raw, key, value string }
+
+	var params []param
+	for _, raw := range strings.Split(query, "&") {
+		if raw == "" {
+			continue
+		}
+
+		key, value := raw, ""
+		if i := strings.IndexByte(raw, '='); i >= 0 {
+			key, value = raw[:i], raw[i+1:]
+		}
+		params = append(params, param{raw, key, value})
+	}
+
+	sort.Slice(params, func(i, j int) bool {
+		switch {
+		case params[i].key != params[j].key:
+			return params[i].key < params[j].key
+		case params[i].value != params[j].value:
+			return params[i].value < params[j].value
+		}
+		// Break ties between `a` and `a=`.
+		return params[i].raw < params[j].raw
+	})
+
+	sorted := make([]string, len(params))
+	for i, p := range params {
+		sorted[i] = p.raw
+	}
+	return strings.Join(sorted, "&")
+}
+
+func (c Canonicalizer) signedHeaders(r *http.Request) string {
+	names := c.allSignedHeaders()
+	values := make([]string, len(names))
+	for i, name := range names {
+		key := http.CanonicalHeaderKey(name)
+		if c.LowercaseHeaderNames {
+			key = strings.ToLower(key)
+		}
+		values[i] = key + ":" + r.Header.Get(name)
+	}
+	return strings.Join(values, ",")
+}
+
+// allSignedHeaders returns the SignedHeaders followed by the
+// OptionalSignedHeaders.
+func (c Canonicalizer) allSignedHeaders() []string {
+	if len(c.OptionalSignedHeaders) == 0 {
+		return c.SignedHeaders
+	}
+
+	names := make([]string, 0, len(c.SignedHeaders)+len(c.OptionalSignedHeaders))
+	names = append(names, c.SignedHeaders...)
+	return append(names, c.OptionalSignedHeaders...)
+}
+
+func (c Canonicalizer) host(r *http.Request) string {
+	if c.Host != "" {
+		return strings.ToLower(c.Host)
+	}
+
+	host := r.Host
+	if host == "" && r.URL != nil {
+		host = r.URL.Host
+	}
+
+	if c.ForwardedHostHeader != "" && c.fromTrustedProxy(r) {
+		forwarded := strings.Split(r.Header.Get(c.ForwardedHostHeader), ",")
+		if first := strings.TrimSpace(forwarded[0]); first != "" {
+			host = first
+		}
+	}
+
+	return strings.ToLower(host)
+}
+
+func (c Canonicalizer) clientCertificate(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c Canonicalizer) aad(r *http.Request) string {
+	return base64.StdEncoding.EncodeToString(c.AAD)
+}
+
+func (c Canonicalizer) fromTrustedProxy(r *http.Request) bool {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range c.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c Canonicalizer) date(r *http.Request) string {
+	return r.Header.Get(c.dateHeader())
+}
+
+func (c Canonicalizer) dateHeader() string {
+	if c.ExpiresHeader != "" {
+		return c.ExpiresHeader
+	}
+	if c.DateHeader == "" {
+		return "Date"
+	}
+	return c.DateHeader
+}
+
+func (c Canonicalizer) integrityValue(r *http.Request) string {
+	name := c.integrityHeader()
+	value := r.Header.Get(name)
+
+	if c.NormalizeContentMD5 && http.CanonicalHeaderKey(name) == "Content-Md5" {
+		return normalizeMD5(value)
+	}
+
+	return value
+}
+
+func (c Canonicalizer) integrityHeader() string {
+	if c.IntegrityHeader == "" {
+		return "Content-MD5"
+	}
+	return c.IntegrityHeader
+}
+
+// MissingHeaders returns the names of all the headers that signing or
+// verifying the request requires but that it lacks, so that a client can
+// be told of every one at once. Names are given as configured, such as
+// Content-MD5. They are listed in the order in which
+// Sign and Verify check for them, which is the order of their errors:
+// the Date (or DateHeader), each of the SignedHeaders, Host, and, for
+// requests with a body, Content-Type and the integrity header. A nil
+// request lacks none.
+func (c Canonicalizer) MissingHeaders(r *http.Request) []string {
+	return c.missingHeaders(r, false)
+}
+
+// missingHeaders returns the headers MissingHeaders does, also requiring
+// the body headers of requests without a body if requireContent is set.
+func (c Canonicalizer) missingHeaders(r *http.Request, requireContent bool) []string {
+	if r == nil {
+		return nil
+	}
+
+	var missing []string
+	if c.date(r) == "" {
+		missing = append(missing, c.dateHeader())
+	}
+
+	for _, name := range c.SignedHeaders {
+		if len(r.Header[http.CanonicalHeaderKey(name)]) == 0 {
+			missing = append(missing, name)
+		}
+	}
+
+	if (c.IncludeHost || c.FullURL) && c.host(r) == "" {
+		missing = append(missing, "Host")
+	}
+
+	if requireContent || r.Body != nil && r.Body != http.NoBody {
+		if !c.OptionalContentType && r.Header.Get("Content-Type") == "" {
+			missing = append(missing, "Content-Type")
+		}
+		if integrity := c.integrityHeader(); r.Header.Get(integrity) == "" {
+			missing = append(missing, integrity)
+		}
+	}
+
+	return missing
+}
+
+// sufficientHeaders checks for the headers signing and verifying require,
+// returning the error for the first that is missing in the order
+// MissingHeaders lists them.
+func (c Canonicalizer) sufficientHeaders(r *http.Request) error {
+	if err := checkRequest(r); err != nil {
+		return err
+	}
+
+	if c.date(r) == "" {
+		return missingHeader(c.dateHeader())
+	}
+
+	return c.sufficientHeadersExceptDate(r)
+}
+
+// sufficientHeadersExceptDate checks the headers required besides the
+// Date, for schemes that carry the signing time elsewhere.
+func (c Canonicalizer) sufficientHeadersExceptDate(r *http.Request) error {
+	if err := checkRequest(r); err != nil {
+		return err
+	}
+
+	for _, name := range c.SignedHeaders {
+		if len(r.Header[http.CanonicalHeaderKey(name)]) == 0 {
+			return ErrMissingSignedHeader
+		}
+	}
+
+	if (c.IncludeHost || c.FullURL) && c.host(r) == "" {
+		return ErrMissingHost
+	}
+
+	if c.BindClientCertificate && c.clientCertificate(r) == "" {
+		return ErrMissingClientCertificate
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	if !c.OptionalContentType && r.Header.Get("Content-Type") == "" {
+		return ErrMissingContentType
+	}
+
+	integrity := c.integrityHeader()
+	if r.Header.Get(integrity) == "" {
+		return missingHeader(integrity)
+	}
+
+	return nil
+}
+
+// checkRequest returns ErrNilRequest for a nil request, and gives a
+// request built without a header map an empty one, so that it can be
+// read and modified without panicking.
+func checkRequest(r *http.Request) error {
+	if r == nil {
+		return ErrNilRequest
+	}
+
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+
+	return nil
+}
@@ -0,0 +1,598 @@
+package apiauth
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalizer_SignedHeaders(t *testing.T) {
+	c := Canonicalizer{SignedHeaders: []string{"x-request-id", "Accept"}}
+
+	req, _ := http.NewRequest("GET", "http://example.com/some/path", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("X-Request-ID", "abc")
+	req.Header.Set("Accept", "application/json")
+
+	want := ",,/some/path,Fri, 20 Mar 2015 19:37:40 GMT,X-Request-Id:abc,Accept:application/json"
+	require.Equal(t, want, c.CanonicalString(req))
+}
+
+func TestVerifier_MissingSignedHeader(t *testing.T) {
+	c := Canonicalizer{SignedHeaders: []string{"X-Request-ID"}}
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+
+	s := Signer{AccessID: "me", Secret: "secret", Canonicalizer: c}
+	require.Equal(t, ErrMissingSignedHeader, s.Sign(req))
+
+	req.Header.Set("X-Request-ID", "abc")
+	require.NoError(t, s.Sign(req))
+
+	v := Verifier{Secret: "secret", Canonicalizer: c}
+	require.NoError(t, v.Verify(req))
+
+	req.Header.Del("X-Request-ID")
+	require.Equal(t, ErrMissingSignedHeader, v.Verify(req))
+
+	req.Header.Set("X-Request-ID", "")
+	require.Error(t, v.Verify(req))
+}
+
+func TestCanonicalizer_IncludeHost(t *testing.T) {
+	c := Canonicalizer{IncludeHost: true}
+
+	req, _ := http.NewRequest("GET", "http://Example.com/some/path", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.Equal(t, ",,example.com,/some/path,Fri, 20 Mar 2015 19:37:40 GMT", c.CanonicalString(req))
+
+	req.Host = "api.example.com:8443"
+	require.Equal(t, ",,api.example.com:8443,/some/path,Fri, 20 Mar 2015 19:37:40 GMT", c.CanonicalString(req))
+}
+
+func TestCanonicalizer_ForwardedHost(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	c := Canonicalizer{
+		IncludeHost:         true,
+		ForwardedHostHeader: "X-Forwarded-Host",
+		TrustedProxies:      []*net.IPNet{proxies},
+	}
+
+	req, _ := http.NewRequest("GET", "http://internal:8080/", nil)
+	req.Header.Set("X-Forwarded-Host", "api.example.com, lb.example.com")
+
+	req.RemoteAddr = "10.1.2.3:51234"
+	require.Equal(t, ",,api.example.com,/,", c.CanonicalString(req))
+
+	req.RemoteAddr = "203.0.113.9:51234"
+	require.Equal(t, ",,internal:8080,/,", c.CanonicalString(req))
+}
+
+func TestCanonicalizer_NormalizeContentMD5(t *testing.T) {
+	body := []byte(`post body`)
+	padded := base64md5(body)
+	sum := md5.Sum(body)
+
+	for _, variant := range []string{
+		padded,
+		strings.TrimRight(padded, "="),
+		base64.URLEncoding.EncodeToString(sum[:]),
+		hex.EncodeToString(sum[:]),
+		strings.ToUpper(hex.EncodeToString(sum[:])),
+	} {
+		req, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader(body))
+		req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Content-MD5", variant)
+
+		c := Canonicalizer{NormalizeContentMD5: true}
+		require.Equal(t, "text/plain,"+padded+",/,Fri, 20 Mar 2015 19:37:40 GMT", c.CanonicalString(req), variant)
+
+		// Signed by a client that normalized, or sent the padded form.
+		req.Header.Set("Content-MD5", padded)
+		require.NoError(t, Sign(req, "me", "secret"))
+
+		v := Verifier{Secret: "secret", Canonicalizer: c}
+		req.Header.Set("Content-MD5", variant)
+		require.NoError(t, v.VerifyWithBodyReader(req), variant)
+	}
+
+	c := Canonicalizer{NormalizeContentMD5: true}
+	req, _ := http.NewRequest("POST", "http://example.com", nil)
+	req.Header.Set("Content-MD5", "not an md5")
+	require.Equal(t, ",not an md5,/,", c.CanonicalString(req))
+}
+
+func TestCanonicalizer_SignedAccept(t *testing.T) {
+	c := Canonicalizer{SignedHeaders: []string{"Accept"}}
+
+	req, _ := http.NewRequest("GET", "http://example.com/report", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Accept", "application/json")
+
+	s := Signer{AccessID: "me", Secret: "secret", Canonicalizer: c}
+	require.NoError(t, s.Sign(req))
+
+	v := Verifier{Secret: "secret", Canonicalizer: c}
+	require.NoError(t, v.Verify(req))
+	require.Error(t, Verify(req, "secret"))
+
+	req.Header.Set("Accept", "text/csv")
+	require.Equal(t, ErrSignatureMismatch, v.Verify(req))
+}
+
+func TestDefaultBuilder(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com/some/path?x=1&b=2", nil)
+	req.Header.Add("Content-Type", "text/plain")
+	req.Header.Add("Content-MD5", "WnNni3tnQAUFZDSkgFRwfQ==")
+	req.Header.Add("Date", "Thu, 19 Mar 2015 19:24:24 GMT")
+
+	want := "text/plain,WnNni3tnQAUFZDSkgFRwfQ==,/some/path?x=1&b=2,Thu, 19 Mar 2015 19:24:24 GMT"
+	require.Equal(t, want, DefaultBuilder.CanonicalString(req))
+
+	c := Canonicalizer{Fields: []CanonicalField{FieldContentType, FieldIntegrity, FieldURI, FieldDate}}
+	require.Equal(t, want, c.CanonicalString(req))
+	require.Equal(t, "POST,"+want, c.CanonicalStringWithMethod(req))
+}
+
+func TestCanonicalizer_Fields(t *testing.T) {
+	req, _ := http.NewRequest("get", "http://Example.com/some/path", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("X-Request-ID", "abc")
+
+	c := Canonicalizer{
+		Fields:        []CanonicalField{FieldDate, FieldMethod, FieldHost, FieldURI, FieldSignedHeaders},
+		SignedHeaders: []string{"X-Request-ID"},
+	}
+	require.Equal(t, "Fri, 20 Mar 2015 19:37:40 GMT,GET,example.com,/some/path,X-Request-Id:abc", c.CanonicalString(req))
+
+	s := Signer{AccessID: "me", Secret: "secret", Canonicalizer: c}
+	require.NoError(t, s.Sign(req))
+
+	v := Verifier{Secret: "secret", Canonicalizer: c}
+	require.NoError(t, v.Verify(req))
+	require.Error(t, Verify(req, "secret"))
+}
+
+type pathOnlyBuilder struct{}
+
+func (pathOnlyBuilder) CanonicalString(r *http.Request) string {
+	return r.URL.Path
+}
+
+func TestCanonicalBuilder(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/some/path", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+
+	s := Signer{AccessID: "me", Secret: "secret", Builder: pathOnlyBuilder{}}
+	require.NoError(t, s.Sign(req))
+	require.Equal(t, "APIAuth me:"+Compute("/some/path", "secret"), req.Header.Get("Authorization"))
+
+	v := Verifier{Secret: "secret", Builder: pathOnlyBuilder{}}
+	require.NoError(t, v.Verify(req))
+
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:41 GMT")
+	require.NoError(t, v.Verify(req))
+	require.Error(t, Verify(req, "secret"))
+}
+
+func TestCanonicalizer_IncludeHostRelativeURL(t *testing.T) {
+	c := Canonicalizer{IncludeHost: true}
+	s := Signer{AccessID: "me", Secret: "secret", Canonicalizer: c}
+
+	req, _ := http.NewRequest("GET", "/api/thing", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.Equal(t, ErrMissingHost, s.Sign(req))
+
+	req.Host = "API.example.com"
+	require.Equal(t, ",,api.example.com,/api/thing,Fri, 20 Mar 2015 19:37:40 GMT", c.CanonicalString(req))
+	require.NoError(t, s.Sign(req))
+
+	server := httptest.NewRequest("GET", "/api/thing", nil)
+	server.Host = "api.example.com"
+	server.Header = req.Header
+	v := Verifier{Secret: "secret", Canonicalizer: c}
+	require.NoError(t, v.Verify(server))
+
+	server.Host = "evil.example.com"
+	require.Equal(t, ErrSignatureMismatch, v.Verify(server))
+}
+
+func TestMethodBuilders(t *testing.T) {
+	pathOnly := Canonicalizer{Fields: []CanonicalField{FieldContentType, FieldIntegrity, FieldPath, FieldDate}}
+	builders := map[string]CanonicalBuilder{"GET": pathOnly}
+
+	s := Signer{AccessID: "me", Secret: "secret", WithMethod: true, MethodBuilders: builders}
+	v := Verifier{Secret: "secret", MethodBuilders: builders}
+
+	get, _ := http.NewRequest("GET", "http://example.com/items?idempotency=1", nil)
+	get.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.Equal(t, ",,/items,Fri, 20 Mar 2015 19:37:40 GMT", pathOnly.CanonicalString(get))
+	require.NoError(t, s.Sign(get))
+
+	get.URL.RawQuery = "idempotency=2"
+	require.NoError(t, v.Verify(get))
+
+	post, _ := http.NewRequest("POST", "http://example.com/items?idempotency=1", nil)
+	post.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.NoError(t, s.Sign(post))
+	require.NoError(t, v.Verify(post))
+
+	post.URL.RawQuery = "idempotency=2"
+	require.Equal(t, ErrSignatureMismatch, v.Verify(post))
+}
+
+func TestCanonicalizer_NormalizeQuery(t *testing.T) {
+	c := Canonicalizer{NormalizeQuery: true}
+	s := Signer{AccessID: "me", Secret: "secret", Canonicalizer: c}
+	v := Verifier{Secret: "secret", Canonicalizer: c}
+
+	newRequest := func(rawQuery string) *http.Request {
+		req, _ := http.NewRequest("GET", "http://example.com/search?"+rawQuery, nil)
+		req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+		return req
+	}
+
+	req := newRequest("q=a+b&plus=1%2B1")
+	require.Equal(t, ",,/search?q=a%20b&plus=1%2B1,Fri, 20 Mar 2015 19:37:40 GMT", c.CanonicalString(req))
+	require.Equal(t, c.CanonicalString(req), c.CanonicalString(newRequest("q=a%20b&plus=1%2B1")))
+	require.NotEqual(t, c.CanonicalString(req), c.CanonicalString(newRequest("q=a%2Bb&plus=1%2B1")))
+
+	// Without the option, a re-encoded query does not verify.
+	client := newRequest("q=a+b&plus=1%2B1")
+	require.NoError(t, Sign(client, "me", "secret"))
+	server := newRequest("q=a%20b&plus=1%2B1")
+	server.Header = client.Header
+	require.Equal(t, ErrSignatureMismatch, Verify(server, "secret"))
+
+	// With it, either encoding verifies against the other.
+	for _, queries := range [][2]string{
+		{"q=a+b&plus=1%2B1", "q=a%20b&plus=1%2B1"},
+		{"q=a%20b&plus=1%2B1", "q=a+b&plus=1%2B1"},
+	} {
+		client := newRequest(queries[0])
+		require.NoError(t, s.Sign(client))
+		server := newRequest(queries[1])
+		server.Header = client.Header
+		require.NoError(t, v.Verify(server), queries[0])
+	}
+}
+
+func TestCanonicalizer_SortQuery(t *testing.T) {
+	c := Canonicalizer{SortQuery: true}
+	s := Signer{AccessID: "me", Secret: "secret", Canonicalizer: c}
+	v := Verifier{Secret: "secret", Canonicalizer: c}
+
+	newRequest := func(rawQuery string) *http.Request {
+		req, _ := http.NewRequest("GET", "http://example.com/search?"+rawQuery, nil)
+		req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+		return req
+	}
+
+	req := newRequest("b=1&a=2&a=1&a=10&c&&a")
+	require.Equal(t, ",,/search?a&a=1&a=10&a=2&b=1&c,Fri, 20 Mar 2015 19:37:40 GMT", c.CanonicalString(req))
+	require.Equal(t, c.CanonicalString(req), c.CanonicalString(newRequest("c&a=10&a&a=1&b=1&a=2")))
+	require.NotEqual(t, c.CanonicalString(req), c.CanonicalString(newRequest("b=1&a=2&a=1&a=10&c")))
+
+	client := newRequest("a=2&a=1")
+	require.NoError(t, s.Sign(client))
+	server := newRequest("a=1&a=2")
+	server.Header = client.Header
+	require.NoError(t, v.Verify(server))
+	require.Equal(t, ErrSignatureMismatch, Verify(client, "secret"))
+
+	// Values are compared as sent.
+	server = newRequest("a=1&a=%32")
+	server.Header = client.Header
+	require.Equal(t, ErrSignatureMismatch, v.Verify(server))
+}
+
+func TestCanonicalizer_LowercaseHeaderNames(t *testing.T) {
+	c := Canonicalizer{SignedHeaders: []string{"X-Request-ID", "accept"}, LowercaseHeaderNames: true}
+
+	req, _ := http.NewRequest("GET", "http://example.com/some/path", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("X-Request-ID", "abc")
+	req.Header.Set("Accept", "application/json")
+
+	want := ",,/some/path,Fri, 20 Mar 2015 19:37:40 GMT,x-request-id:abc,accept:application/json"
+	require.Equal(t, want, c.CanonicalString(req))
+
+	// Computed outside Go, with Python's hmac module, over the lowercased
+	// layout used by the peer implementation.
+	req.Header.Set("Authorization", "APIAuth me:Vyp/p89PoxTSV6jTyo1R6WHv/vg=")
+	v := Verifier{Secret: "secret", Builders: []CanonicalBuilder{c}}
+	require.NoError(t, v.Verify(req))
+
+	c.LowercaseHeaderNames = false
+	v.Builders = []CanonicalBuilder{c}
+	require.Equal(t, ErrSignatureMismatch, v.Verify(req))
+}
+
+func TestCanonicalizer_TrailingSlash(t *testing.T) {
+	for mode, paths := range map[TrailingSlash]map[string]string{
+		KeepTrailingSlash:  {"/": "/", "/resource": "/resource", "/resource/": "/resource/"},
+		StripTrailingSlash: {"/": "/", "/resource": "/resource", "/resource/": "/resource", "/resource//": "/resource/"},
+		AddTrailingSlash:   {"/": "/", "/resource": "/resource/", "/resource/": "/resource/"},
+	} {
+		c := Canonicalizer{TrailingSlash: mode}
+		for path, want := range paths {
+			req, _ := http.NewRequest("GET", "http://example.com"+path+"?x=1", nil)
+			require.Equal(t, ",,"+want+"?x=1,", c.CanonicalString(req), path)
+		}
+	}
+
+	signed, _ := http.NewRequest("GET", "http://example.com/resource", nil)
+	signed.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+
+	for _, mode := range []TrailingSlash{StripTrailingSlash, AddTrailingSlash} {
+		s := Signer{AccessID: "me", Secret: "secret", WithMethod: true, Canonicalizer: Canonicalizer{TrailingSlash: mode}}
+		header, err := s.SignHeader(signed)
+		require.NoError(t, err)
+
+		routed, _ := http.NewRequest("GET", "http://example.com/resource/", nil)
+		routed.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+		routed.Header.Set("Authorization", header)
+
+		v := Verifier{Secret: "secret", Canonicalizer: Canonicalizer{TrailingSlash: mode}}
+		require.NoError(t, v.Verify(routed), mode)
+	}
+
+	// Without the option, the paths differ.
+	routed, _ := http.NewRequest("GET", "http://example.com/resource/", nil)
+	routed.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	header, err := SignHeader(signed, "me", "secret")
+	require.NoError(t, err)
+	routed.Header.Set("Authorization", header)
+	require.Equal(t, ErrSignatureMismatch, Verify(routed, "secret"))
+}
+
+func TestCanonicalizer_MethodOverrideHeader(t *testing.T) {
+	c := Canonicalizer{MethodOverrideHeader: "X-HTTP-Method-Override"}
+
+	req, _ := http.NewRequest("POST", "http://example.com/some/path", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.Equal(t, "POST,,,/some/path,Fri, 20 Mar 2015 19:37:40 GMT", c.CanonicalStringWithMethod(req))
+
+	req.Header.Set("X-HTTP-Method-Override", "delete")
+	require.Equal(t, "DELETE,,,/some/path,Fri, 20 Mar 2015 19:37:40 GMT", c.CanonicalStringWithMethod(req))
+	require.Equal(t, "POST,,,/some/path,Fri, 20 Mar 2015 19:37:40 GMT", CanonicalStringWithMethod(req))
+
+	c.Fields = []CanonicalField{FieldMethod, FieldPath}
+	require.Equal(t, "DELETE,/some/path", c.CanonicalString(req))
+	c.Fields = nil
+
+	s := Signer{AccessID: "me", Secret: "secret", WithMethod: true, Canonicalizer: c}
+	require.NoError(t, s.Sign(req))
+
+	strict := []CanonicalBuilder{WithMethod(c)}
+	v := Verifier{Secret: "secret", Builders: strict, Canonicalizer: c}
+	require.NoError(t, v.Verify(req))
+
+	// Changing the override, or dropping it, breaks the signature.
+	req.Header.Set("X-HTTP-Method-Override", "PUT")
+	require.Equal(t, ErrSignatureMismatch, v.Verify(req))
+
+	req.Header.Del("X-HTTP-Method-Override")
+	require.Equal(t, ErrSignatureMismatch, v.Verify(req))
+
+	// A server unaware of the override sees the transport method.
+	req.Header.Set("X-HTTP-Method-Override", "delete")
+	unaware := Verifier{Secret: "secret", Builders: []CanonicalBuilder{WithMethod(DefaultBuilder)}}
+	require.Equal(t, ErrSignatureMismatch, unaware.Verify(req))
+}
+
+func TestCanonicalComponents(t *testing.T) {
+	req, _ := http.NewRequest("put", "http://example.com/some/path?x=1", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-MD5", "mZFLkyvTelC5g8XnyQrpOw==")
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+
+	want := []CanonicalComponent{
+		{"Method", "PUT"},
+		{"ContentType", "application/json"},
+		{"ContentMD5", "mZFLkyvTelC5g8XnyQrpOw=="},
+		{"URI", "/some/path?x=1"},
+		{"Date", "Fri, 20 Mar 2015 19:37:40 GMT"},
+	}
+	require.Equal(t, want, CanonicalComponents(req))
+	require.Equal(t, CanonicalStringWithMethod(req), joinComponents(CanonicalComponents(req)))
+
+	req.Header.Set("Accept", "text/plain")
+	req.Header.Set(DigestHeader, "SHA-256=RBNvo1WzZ4oRRq0W9+hknpT7T8If536DEMBg9hyq/4o=")
+	c := Canonicalizer{IncludeHost: true, IntegrityHeader: DigestHeader, SignedHeaders: []string{"Accept", "Date"}}
+	want = []CanonicalComponent{
+		{"ContentType", "application/json"},
+		{DigestHeader, "SHA-256=RBNvo1WzZ4oRRq0W9+hknpT7T8If536DEMBg9hyq/4o="},
+		{"Host", "example.com"},
+		{"URI", "/some/path?x=1"},
+		{"Date", "Fri, 20 Mar 2015 19:37:40 GMT"},
+		{"SignedHeader", "Accept:text/plain"},
+		{"SignedHeader", "Date:Fri, 20 Mar 2015 19:37:40 GMT"},
+	}
+	require.Equal(t, want, c.CanonicalComponents(req))
+	require.Equal(t, c.CanonicalString(req), joinComponents(c.CanonicalComponents(req)))
+
+	c = Canonicalizer{Fields: []CanonicalField{FieldMethod, FieldPath}}
+	require.Equal(t, []CanonicalComponent{{"", "PUT"}, {"", "/some/path"}}, c.CanonicalComponents(req))
+
+	require.Nil(t, CanonicalComponents(nil))
+}
+
+func joinComponents(components []CanonicalComponent) string {
+	values := make([]string, len(components))
+	for i, component := range components {
+		values[i] = component.Value
+	}
+	return strings.Join(values, ",")
+}
+
+func TestCanonicalizer_RFC3986Path(t *testing.T) {
+	c := Canonicalizer{RFC3986Path: true}
+
+	req, _ := http.NewRequest("GET", "http://example.com/files/a%20b+c=d:e@f!(g)~h%2Fi/%7Euser?x=1", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.Equal(t, "/files/a%20b+c=d:e@f!(g)~h%2Fi/%7Euser", Canonicalizer{}.path(req))
+	require.Equal(t, "/files/a%20b%2Bc%3Dd%3Ae%40f%21%28g%29~h%2Fi/~user", c.path(req))
+
+	// Signed by a peer encoding its paths strictly per RFC 3986.
+	req.Header.Set("Authorization", "APIAuth me:C50Ksj9lEeAwoS4g9nitepsNOIc=")
+	v := Verifier{Secret: "secret", Canonicalizer: c}
+	require.NoError(t, v.Verify(req))
+	require.Equal(t, ErrSignatureMismatch, Verify(req, "secret"))
+
+	for path, want := range map[string]string{
+		"/":          "/",
+		"/a/b/":      "/a/b/",
+		"/%e2%82%ac": "/%E2%82%AC",
+		"/café":      "/caf%C3%A9",
+		"/100%":      "/100%25",
+		"/a%2fb":     "/a%2Fb",
+	} {
+		require.Equal(t, want, rfc3986Encode(path), path)
+	}
+}
+
+func TestCanonicalizer_OptionalContentType(t *testing.T) {
+	c := Canonicalizer{OptionalContentType: true}
+
+	req, _ := http.NewRequest("POST", "http://example.com/", strings.NewReader("hello"))
+	req.Header.Set("Content-MD5", "XUFAKrxLKna5cZ2REBfFkg==")
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+
+	require.Equal(t, ErrMissingContentType, SignWithMethod(req, "me", "secret"))
+
+	s := Signer{AccessID: "me", Secret: "secret", WithMethod: true, Canonicalizer: c}
+	require.NoError(t, s.Sign(req))
+	require.Equal(t, "POST,,XUFAKrxLKna5cZ2REBfFkg==,/,Fri, 20 Mar 2015 19:37:40 GMT", c.CanonicalStringWithMethod(req))
+
+	require.Equal(t, ErrMissingContentType, Verify(req, "secret"))
+
+	v := Verifier{Secret: "secret", Canonicalizer: c}
+	require.NoError(t, v.Verify(req))
+
+	// Content-MD5 is still required.
+	req.Header.Del("Content-MD5")
+	require.Equal(t, ErrMissingContentMD5, v.Verify(req))
+}
+
+func TestCanonicalizer_BindClientCertificate(t *testing.T) {
+	c := Canonicalizer{BindClientCertificate: true}
+	cert := []byte("a DER-encoded certificate")
+	fingerprint := sha256.Sum256(cert)
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+
+	s := Signer{AccessID: "me", Secret: "secret", WithMethod: true, Canonicalizer: c}
+	require.Equal(t, ErrMissingClientCertificate, s.Sign(req))
+
+	s.ClientCertificate = cert
+	require.NoError(t, s.Sign(req))
+	require.Nil(t, req.TLS)
+
+	// As the server sees it, after the TLS handshake.
+	server := *req
+	server.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: cert}}}
+	require.Equal(t, "GET,,,/,Fri, 20 Mar 2015 19:37:40 GMT,"+hex.EncodeToString(fingerprint[:]), c.CanonicalStringWithMethod(&server))
+
+	v := Verifier{Secret: "secret", Canonicalizer: c}
+	require.NoError(t, v.Verify(&server))
+
+	server.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: []byte("another certificate")}}}
+	require.Equal(t, ErrSignatureMismatch, v.Verify(&server))
+
+	server.TLS = &tls.ConnectionState{}
+	require.Equal(t, ErrMissingClientCertificate, v.Verify(&server))
+	require.Equal(t, ErrMissingClientCertificate, v.Verify(req))
+
+	// The signature lands on a request without headers, not on the copy
+	// bound to the certificate.
+	bare := &http.Request{Method: "GET", URL: req.URL}
+	require.Equal(t, ErrMissingDate, s.Sign(bare))
+	bare.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.NoError(t, s.Sign(bare))
+	require.Equal(t, req.Header.Get("Authorization"), bare.Header.Get("Authorization"))
+
+	require.Equal(t, ErrNilRequest, s.Sign(nil))
+	_, err := s.SignHeader(nil)
+	require.Equal(t, ErrNilRequest, err)
+	_, err = s.Signature(nil)
+	require.Equal(t, ErrNilRequest, err)
+	require.Equal(t, ErrNilRequest, s.SignV2(nil, HMACSHA256))
+}
+
+func TestCanonicalizer_AAD(t *testing.T) {
+	c := Canonicalizer{AAD: []byte("session\x00,1"), SignedHeaders: []string{"X-Request-Id"}}
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("X-Request-Id", "42")
+	require.Equal(t, ",,/,Fri, 20 Mar 2015 19:37:40 GMT,X-Request-Id:42,c2Vzc2lvbgAsMQ==", c.CanonicalString(req))
+
+	components := c.CanonicalComponents(req)
+	require.Equal(t, CanonicalComponent{"AAD", "c2Vzc2lvbgAsMQ=="}, components[len(components)-1])
+
+	s := Signer{AccessID: "me", Secret: "secret", Canonicalizer: c}
+	require.NoError(t, s.SignV2(req, HMACSHA256))
+
+	v := Verifier{Secret: "secret", Canonicalizer: c}
+	require.NoError(t, v.VerifyV2(req))
+
+	v.AAD = []byte("session\x00,2")
+	require.Equal(t, ErrSignatureMismatch, v.VerifyV2(req))
+
+	// With custom Fields, AAD is only signed through FieldAAD.
+	custom := Canonicalizer{AAD: []byte("session"), Fields: []CanonicalField{FieldURI, FieldAAD}}
+	require.Equal(t, "/,c2Vzc2lvbg==", custom.CanonicalString(req))
+}
+
+func TestCanonicalizer_FullURL(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://API.example.com/hooks/1?x=1", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.Equal(t, ",,https://api.example.com/hooks/1?x=1,Fri, 20 Mar 2015 19:37:40 GMT", CanonicalStringFullURL(req))
+
+	s, err := NewSigner("me", "secret", WithFullURL("", ""))
+	require.NoError(t, err)
+	require.NoError(t, s.Sign(req))
+
+	server := httptest.NewRequest("GET", "/hooks/1?x=1", nil)
+	server.Host = "api.example.com"
+	server.Header = req.Header
+
+	v := Verifier{Secret: "secret", Canonicalizer: Canonicalizer{FullURL: true}}
+	require.Equal(t, ErrSignatureMismatch, v.Verify(server))
+
+	v.Scheme = "https"
+	require.NoError(t, v.Verify(server))
+
+	server.TLS = &tls.ConnectionState{}
+	v.Scheme = ""
+	require.NoError(t, v.Verify(server))
+
+	server.Host = "evil.example.com"
+	require.Equal(t, ErrSignatureMismatch, v.Verify(server))
+
+	pinned, err := NewVerifier("secret", WithFullURL("https", "api.example.com"))
+	require.NoError(t, err)
+	require.NoError(t, pinned.Verify(server))
+
+	server.Host = ""
+	require.NoError(t, pinned.Verify(server))
+	require.Equal(t, ErrMissingHost, v.Verify(server))
+
+	_, err = NewVerifier("secret", WithFullURL("ftp", ""))
+	require.Error(t, err)
+}
@@ -0,0 +1,50 @@
+package apiauth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// DefaultCredentialOptions are the lengths used by GenerateCredentials.
+var DefaultCredentialOptions = CredentialOptions{
+	AccessIDBytes: 16,
+	SecretBytes:   64,
+}
+
+// CredentialOptions sets the number of random bytes used to generate
+// each part of a credential pair. Zero values fall back to
+// DefaultCredentialOptions.
+type CredentialOptions struct {
+	AccessIDBytes int
+	SecretBytes   int
+}
+
+// GenerateCredentials returns a new random access ID, encoded as URL-safe
+// base64 without padding, and a new random secret, encoded as standard
+// base64, using crypto/rand and the lengths in DefaultCredentialOptions.
+func GenerateCredentials() (accessID, secret string, err error) {
+	return GenerateCredentialsWithOptions(DefaultCredentialOptions)
+}
+
+// GenerateCredentialsWithOptions is as GenerateCredentials, but with the
+// given lengths.
+func GenerateCredentialsWithOptions(opts CredentialOptions) (accessID, secret string, err error) {
+	if opts.AccessIDBytes <= 0 {
+		opts.AccessIDBytes = DefaultCredentialOptions.AccessIDBytes
+	}
+	if opts.SecretBytes <= 0 {
+		opts.SecretBytes = DefaultCredentialOptions.SecretBytes
+	}
+
+	id := make([]byte, opts.AccessIDBytes)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+
+	key := make([]byte, opts.SecretBytes)
+	if _, err := rand.Read(key); err != nil {
+		return "", "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(id), base64.StdEncoding.EncodeToString(key), nil
+}
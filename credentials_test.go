@@ -0,0 +1,43 @@
+package apiauth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateCredentials(t *testing.T) {
+	id, secret, err := GenerateCredentials()
+	require.NoError(t, err)
+
+	rawID, err := base64.RawURLEncoding.DecodeString(id)
+	require.NoError(t, err)
+	require.Len(t, rawID, 16)
+
+	rawSecret, err := base64.StdEncoding.DecodeString(secret)
+	require.NoError(t, err)
+	require.Len(t, rawSecret, 64)
+
+	id2, secret2, err := GenerateCredentials()
+	require.NoError(t, err)
+	require.NotEqual(t, id, id2)
+	require.NotEqual(t, secret, secret2)
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", Date())
+	require.NoError(t, Sign(req, id, secret))
+	require.NoError(t, Verify(req, secret))
+}
+
+func TestGenerateCredentialsWithOptions(t *testing.T) {
+	id, secret, err := GenerateCredentialsWithOptions(CredentialOptions{AccessIDBytes: 6, SecretBytes: 32})
+	require.NoError(t, err)
+	require.Len(t, id, 8)
+	require.Len(t, secret, 44)
+
+	id, _, err = GenerateCredentialsWithOptions(CredentialOptions{SecretBytes: 32})
+	require.NoError(t, err)
+	require.Len(t, id, 22)
+}
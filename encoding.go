@@ -0,0 +1,44 @@
+package apiauth
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// A SignatureEncoding is the text encoding of the raw MAC carried in the
+// Authorization header. The client and server must use the same one.
+type SignatureEncoding int
+
+const (
+	// Base64 is standard, padded base64, as produced by Compute. It is
+	// the default, and the only encoding the Ruby gem understands.
+	Base64 SignatureEncoding = iota
+
+	// Base64URL is unpadded, URL-safe base64.
+	Base64URL
+
+	// Hex is lowercase hexadecimal. Uppercase is accepted when decoding.
+	Hex
+)
+
+// Encode returns the encoded form of a raw MAC.
+func (e SignatureEncoding) Encode(mac []byte) string {
+	switch e {
+	case Base64URL:
+		return base64.RawURLEncoding.EncodeToString(mac)
+	case Hex:
+		return hex.EncodeToString(mac)
+	}
+	return base64.StdEncoding.EncodeToString(mac)
+}
+
+// Decode returns the raw MAC of an encoded signature.
+func (e SignatureEncoding) Decode(sig string) ([]byte, error) {
+	switch e {
+	case Base64URL:
+		return base64.RawURLEncoding.DecodeString(sig)
+	case Hex:
+		return hex.DecodeString(sig)
+	}
+	return base64.StdEncoding.DecodeString(sig)
+}
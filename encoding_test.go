@@ -0,0 +1,43 @@
+package apiauth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignatureEncoding(t *testing.T) {
+	mac := ComputeRaw("GET,,,/,Fri, 20 Mar 2015 19:37:40 GMT", "secret")
+	for enc, want := range map[SignatureEncoding]string{
+		Base64:    Compute("GET,,,/,Fri, 20 Mar 2015 19:37:40 GMT", "secret"),
+		Base64URL: "4ZVi8vChyIWKwRUxmZsFKp5E3RU",
+		Hex:       "e19562f2f0a1c8858ac11531999b052a9e44dd15",
+	} {
+		require.Equal(t, want, enc.Encode(mac), enc)
+
+		decoded, err := enc.Decode(want)
+		require.NoError(t, err)
+		require.Equal(t, mac, decoded)
+	}
+}
+
+func TestSignatureEncoding_RoundTrip(t *testing.T) {
+	for _, enc := range []SignatureEncoding{Base64, Base64URL, Hex} {
+		req, _ := http.NewRequest("GET", "http://example.com/some/path?x=1", nil)
+		req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+
+		s := Signer{AccessID: "me", Secret: "secret", WithMethod: true, Encoding: enc}
+		require.NoError(t, s.Sign(req))
+
+		v := Verifier{Secret: "secret", Encoding: enc}
+		require.NoError(t, v.Verify(req), enc)
+
+		for _, other := range []SignatureEncoding{Base64, Base64URL, Hex} {
+			if other != enc {
+				v := Verifier{Secret: "secret", Encoding: other}
+				require.Equal(t, ErrSignatureMismatch, v.Verify(req), enc)
+			}
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package apiauth
+
+import (
+	"errors"
+	"net/http"
+)
+
+// An AuthError describes why a request could not be verified. Every
+// verification failure is reported as an *AuthError, so callers can use
+// errors.As to retrieve it and marshal it directly into a response body;
+// only I/O errors from reading a body and errors from key lookup functions
+// are returned as they are. Messages never include header values or
+// secrets.
+type AuthError struct {
+	// Code is a stable, machine-readable identifier for the failure.
+	Code string `json:"code"`
+
+	// Message is a human-readable description of the failure.
+	Message string `json:"message"`
+
+	// Status is the suggested HTTP status code for the response.
+	Status int `json:"-"`
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+// A RequestError is an AuthError annotated with the ID the client gave
+// the request that failed, as returned by a Verifier that sets
+// RequestIDHeader, so that failures can be correlated with the client's
+// logs. It marshals as the AuthError with an added request_id.
+type RequestError struct {
+	*AuthError
+	RequestID string `json:"request_id"`
+}
+
+// Unwrap returns the AuthError, so that errors.Is and errors.As see
+// through the annotation.
+func (e *RequestError) Unwrap() error {
+	return e.AuthError
+}
+
+var (
+	// ErrMissingDate is returned when a request has no Date header.
+	ErrMissingDate = &AuthError{"missing_date", "No Date header present", http.StatusBadRequest}
+
+	// ErrMissingContentType is returned when a request with a body, or
+	// any request under RequireContentMD5, has no Content-Type header.
+	ErrMissingContentType = &AuthError{"missing_content_type", "No Content-Type header present", http.StatusBadRequest}
+
+	// ErrMissingContentMD5 is returned when a request with a body, or any
+	// request under RequireContentMD5, has no Content-MD5 header.
+	ErrMissingContentMD5 = &AuthError{"missing_content_md5", "No Content-MD5 header present", http.StatusBadRequest}
+
+	// ErrMissingHost is returned when a Canonicalizer includes the host
+	// but a request has none.
+	ErrMissingHost = &AuthError{"missing_host", "No Host present", http.StatusBadRequest}
+
+	// ErrMissingClientCertificate is returned when a Canonicalizer binds
+	// signatures to the TLS client certificate but the request was not
+	// made with one.
+	ErrMissingClientCertificate = &AuthError{"missing_client_certificate", "No TLS client certificate present", http.StatusUnauthorized}
+
+	// ErrWeakTLS is returned when a request was not made over a TLS
+	// connection at least as recent as a Verifier's MinTLSVersion.
+	ErrWeakTLS = &AuthError{"weak_tls", "TLS version too old", http.StatusForbidden}
+
+	// ErrMissingAuthorization is returned when a request has no
+	// Authorization header.
+	ErrMissingAuthorization = &AuthError{"missing_authorization", "Authorization header not set", http.StatusUnauthorized}
+
+	// ErrMalformedHeader is returned when the Authorization header does
+	// not match the `APIAuth access_id:signature` format.
+	ErrMalformedHeader = &AuthError{"malformed_authorization", "Malformed Authorization header", http.StatusBadRequest}
+
+	// ErrSignatureMismatch is returned when a request's signature does
+	// not match the one computed for it.
+	ErrSignatureMismatch = &AuthError{"signature_mismatch", "Signature mismatch", http.StatusUnauthorized}
+
+	// ErrSuspiciousSignature is returned by a Verifier that sets
+	// RejectSuspiciousSignatures when a signature does not decode to a MAC
+	// of the expected length, which suggests a misconfigured client rather
+	// than a forgery.
+	ErrSuspiciousSignature = &AuthError{"suspicious_signature", "Signature is not a well-formed MAC", http.StatusBadRequest}
+
+	// ErrAccessIDMismatch is returned when a request is correctly signed,
+	// but not with the access ID a Verifier requires.
+	ErrAccessIDMismatch = &AuthError{"access_id_mismatch", "Access ID not permitted", http.StatusForbidden}
+
+	// ErrUncoveredComponent is returned when an HTTP Message Signature
+	// does not cover every component VerifyMessageSignature requires.
+	ErrUncoveredComponent = &AuthError{"uncovered_component", "Signature does not cover the required components", http.StatusBadRequest}
+
+	// ErrMissingSignedHeader is returned when one of a Canonicalizer's
+	// SignedHeaders is absent from a request. Signing or verifying it
+	// as empty would let a signed header be stripped in transit.
+	ErrMissingSignedHeader = &AuthError{"missing_signed_header", "Signed header not present", http.StatusBadRequest}
+
+	// ErrBodyTooLarge is returned by the body-reading helpers when a
+	// request body exceeds the configured MaxBodySize.
+	ErrBodyTooLarge = &AuthError{"body_too_large", "Request body too large", http.StatusRequestEntityTooLarge}
+
+	// ErrContentMD5Mismatch is returned when a request body does not
+	// match its Content-MD5 header.
+	ErrContentMD5Mismatch = &AuthError{"content_md5_mismatch", "Content-MD5 mismatch", http.StatusBadRequest}
+
+	// ErrInvalidJSON is returned when a body that is hashed as normalized
+	// JSON is not valid JSON.
+	ErrInvalidJSON = &AuthError{"invalid_json", "Request body is not valid JSON", http.StatusBadRequest}
+
+	// ErrUnsupportedContentEncoding is returned when a body must be
+	// decoded to check it but its Content-Encoding is not gzip.
+	ErrUnsupportedContentEncoding = &AuthError{"unsupported_content_encoding", "Content-Encoding not supported", http.StatusUnsupportedMediaType}
+
+	// ErrUnsupportedDigest is returned when a Digest header carries no
+	// SHA-256 digest.
+	ErrUnsupportedDigest = &AuthError{"unsupported_digest", "No SHA-256 digest present", http.StatusBadRequest}
+
+	// ErrDigestMismatch is returned when a request body does not match
+	// its Digest header.
+	ErrDigestMismatch = &AuthError{"digest_mismatch", "Digest mismatch", http.StatusBadRequest}
+
+	// ErrMethodNotAllowed is returned when a request's method is not
+	// one of a Verifier's AllowedMethods.
+	ErrMethodNotAllowed = &AuthError{"method_not_allowed", "Request method not allowed", http.StatusMethodNotAllowed}
+
+	// ErrEmptyBodyNotAllowed is returned when a request without a body
+	// uses a method a Verifier with RejectEmptyBody requires one for.
+	ErrEmptyBodyNotAllowed = &AuthError{"empty_body_not_allowed", "Request body required", http.StatusBadRequest}
+
+	// ErrContentTypeNotAllowed is returned when the Content-Type of a
+	// request with a body is not one of a Verifier's AllowedContentTypes.
+	ErrContentTypeNotAllowed = &AuthError{"content_type_not_allowed", "Content-Type not allowed", http.StatusUnsupportedMediaType}
+
+	// ErrInvalidDate is returned when a Verifier checks the age of a
+	// request whose Date header cannot be parsed, or the expiry of one
+	// whose ExpiresHeader cannot.
+	ErrInvalidDate = &AuthError{"invalid_date", "Date header could not be parsed", http.StatusBadRequest}
+
+	// ErrDateTooOld is returned when a request's Date is further in the
+	// past than a Verifier allows, or before its RejectBefore.
+	ErrDateTooOld = &AuthError{"date_too_old", "Date header too old", http.StatusUnauthorized}
+
+	// ErrDateInFuture is returned when a request's Date is further in
+	// the future than a Verifier allows.
+	ErrDateInFuture = &AuthError{"date_in_future", "Date header in the future", http.StatusUnauthorized}
+
+	// ErrExpired is returned when the expiry of a request signed with
+	// an ExpiresHeader, or of a message signature with an expires
+	// parameter, has passed.
+	ErrExpired = &AuthError{"expired", "Request expired", http.StatusUnauthorized}
+
+	// ErrIdempotencyKeyReused is returned when a request's
+	// Idempotency-Key was already used by a verified request under the
+	// same access ID, for a Verifier with an IdempotencyStore.
+	ErrIdempotencyKeyReused = &AuthError{"idempotency_key_reused", "Idempotency-Key already used", http.StatusConflict}
+
+	// ErrDateNotToday is returned when a request's Date is not on the
+	// current UTC day, for a Verifier that sets SameUTCDay.
+	ErrDateNotToday = &AuthError{"date_not_today", "Date header not today", http.StatusUnauthorized}
+
+	// ErrUnsupportedAlgorithm is returned when an Authorization header
+	// names a signature algorithm that is not supported.
+	ErrUnsupportedAlgorithm = &AuthError{"unsupported_algorithm", "Signature algorithm not supported", http.StatusBadRequest}
+
+	// ErrInvalidAccessID is returned when an access ID does not match
+	// the pattern a Verifier with ValidateAccessID checks it against.
+	ErrInvalidAccessID = &AuthError{"invalid_access_id", "Access ID invalid", http.StatusBadRequest}
+
+	// ErrUnknownAccessID is returned by the key functions provided by
+	// this package for access IDs they hold no secret for.
+	ErrUnknownAccessID = &AuthError{"unknown_access_id", "Unknown access ID", http.StatusUnauthorized}
+
+	// ErrNilRequest is returned when a nil *http.Request is passed for
+	// signing or verification.
+	ErrNilRequest = &AuthError{"nil_request", "Request is nil", http.StatusInternalServerError}
+)
+
+// errorCode returns the Code of an AuthError, or "error" for any other
+// error, such as a failure to read a body.
+func errorCode(err error) string {
+	if authErr, ok := err.(*AuthError); ok {
+		return authErr.Code
+	}
+	return "error"
+}
+
+// StatusCode returns the HTTP status code to respond to a request with
+// after verifying it: http.StatusOK for a nil error, the Status of an
+// AuthError (or the AuthError in a RequestError), and
+// http.StatusInternalServerError for any other error, such as a failure
+// to read the body or look up a key.
+func StatusCode(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return authErr.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// missingHeader returns the error for a required header that is absent.
+func missingHeader(name string) error {
+	switch http.CanonicalHeaderKey(name) {
+	case "Date":
+		return ErrMissingDate
+	case "Content-Type":
+		return ErrMissingContentType
+	case "Content-Md5":
+		return ErrMissingContentMD5
+	}
+	return &AuthError{"missing_header", "No " + name + " header present", http.StatusBadRequest}
+}
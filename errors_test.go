@@ -0,0 +1,112 @@
+package apiauth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthError(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth me:bm90IHRoZSBzaWduYXR1cmU=")
+
+	err := Verify(req, "secret")
+	require.True(t, errors.Is(err, ErrSignatureMismatch))
+
+	var authErr *AuthError
+	require.True(t, errors.As(err, &authErr))
+	require.Equal(t, "signature_mismatch", authErr.Code)
+	require.Equal(t, http.StatusUnauthorized, authErr.Status)
+
+	body, err := json.Marshal(authErr)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"code":"signature_mismatch","message":"Signature mismatch"}`, string(body))
+}
+
+func TestAuthError_NonSensitive(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth secret-looking-value")
+
+	err := Verify(req, "secret")
+	require.Equal(t, ErrMalformedHeader, err)
+	require.NotContains(t, err.Error(), "secret-looking-value")
+
+	req.Header.Del("Date")
+	err = Verify(req, "secret")
+	require.Equal(t, ErrMissingDate, err)
+	require.Equal(t, http.StatusBadRequest, err.(*AuthError).Status)
+}
+
+func TestVerifier_RequestIDHeader(t *testing.T) {
+	c := Canonicalizer{SignedHeaders: []string{"X-Request-ID"}}
+	v := Verifier{Secret: "secret", RequestIDHeader: "X-Request-ID", Canonicalizer: c}
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("X-Request-ID", "f3b1c2")
+	s := Signer{AccessID: "me", Secret: "secret", WithMethod: true, Canonicalizer: c}
+	require.NoError(t, s.Sign(req))
+	require.NoError(t, v.Verify(req))
+
+	// The ID is signed, so changing it fails, and is echoed in the error.
+	req.Header.Set("X-Request-ID", "a9d8e7")
+	err := v.Verify(req)
+	require.True(t, errors.Is(err, ErrSignatureMismatch))
+
+	var reqErr *RequestError
+	require.True(t, errors.As(err, &reqErr))
+	require.Equal(t, "a9d8e7", reqErr.RequestID)
+	require.Equal(t, http.StatusUnauthorized, reqErr.Status)
+	require.Equal(t, "Signature mismatch", err.Error())
+
+	var authErr *AuthError
+	require.True(t, errors.As(err, &authErr))
+	require.Equal(t, ErrSignatureMismatch, authErr)
+
+	body, err := json.Marshal(reqErr)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"code":"signature_mismatch","message":"Signature mismatch","request_id":"a9d8e7"}`, string(body))
+
+	// Failures before the signature is checked carry it too.
+	req.Header.Del("Date")
+	require.True(t, errors.As(v.Verify(req), &reqErr))
+	require.Equal(t, ErrMissingDate, reqErr.AuthError)
+
+	// Without an ID, the error is returned as it is.
+	req.Header.Del("X-Request-ID")
+	require.Equal(t, ErrMissingDate, v.Verify(req))
+}
+
+func TestVerifyHTTP(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+
+	status, err := VerifyHTTP(req, "secret")
+	require.Equal(t, ErrMissingAuthorization, err)
+	require.Equal(t, http.StatusUnauthorized, status)
+
+	req.Header.Set("Authorization", "APIAuth me")
+	status, err = VerifyHTTP(req, "secret")
+	require.Equal(t, ErrMalformedHeader, err)
+	require.Equal(t, http.StatusBadRequest, status)
+
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	status, err = VerifyHTTP(req, "other")
+	require.Equal(t, ErrSignatureMismatch, err)
+	require.Equal(t, http.StatusUnauthorized, status)
+
+	status, err = VerifyHTTP(req, "secret")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+
+	v := Verifier{Secret: "secret", AccessID: "you", RequestIDHeader: "X-Request-ID"}
+	req.Header.Set("X-Request-ID", "abc")
+	require.Equal(t, http.StatusForbidden, StatusCode(v.Verify(req)))
+
+	require.Equal(t, http.StatusInternalServerError, StatusCode(errors.New("key store unavailable")))
+}
@@ -0,0 +1,64 @@
+package apiauth
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// A HeaderFormat describes the accepted layouts of the Authorization
+// header value. Its zero value accepts only `APIAuth access_id:signature`.
+type HeaderFormat struct {
+	// AllowEncoded also accepts `APIAuth <base64(access_id:signature)>`,
+	// as sent by some tools, when the value after the scheme contains
+	// no Separator.
+	AllowEncoded bool
+
+	// Separator separates the access ID from the signature, for peers
+	// that use a space or a dot in place of the standard colon. It
+	// defaults to ":".
+	Separator string
+}
+
+// Header returns the Authorization header value for an access ID and
+// signature in the format.
+func (f HeaderFormat) Header(id, sig string) string {
+	return "APIAuth " + id + f.separator() + sig
+}
+
+// Parse returns the access ID and signature present in the given header
+// value, as the package-level Parse does, also accepting the layouts
+// enabled by f.
+func (f HeaderFormat) Parse(header string) (id, sig string, err error) {
+	var rest string
+	var tokens []string
+
+	if !strings.HasPrefix(header, "APIAuth ") {
+		goto malformed
+	}
+
+	rest = header[8:]
+	if f.AllowEncoded && !strings.Contains(rest, f.separator()) {
+		decoded, err := base64.StdEncoding.DecodeString(rest)
+		if err != nil {
+			goto malformed
+		}
+		rest = string(decoded)
+	}
+
+	tokens = strings.SplitN(rest, f.separator(), 2)
+	if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+		goto malformed
+	}
+
+	return tokens[0], tokens[1], nil
+
+malformed:
+	return "", "", ErrMalformedHeader
+}
+
+func (f HeaderFormat) separator() string {
+	if f.Separator == "" {
+		return ":"
+	}
+	return f.Separator
+}
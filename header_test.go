@@ -0,0 +1,73 @@
+package apiauth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderFormat_AllowEncoded(t *testing.T) {
+	encoded := "APIAuth " + base64.StdEncoding.EncodeToString([]byte("me:N7N1BXAWv6+RXos4vSAAd7D0XJY="))
+
+	_, _, err := Parse(encoded)
+	require.Equal(t, ErrMalformedHeader, err)
+
+	f := HeaderFormat{AllowEncoded: true}
+	id, sig, err := f.Parse(encoded)
+	require.NoError(t, err)
+	require.Equal(t, "me", id)
+	require.Equal(t, "N7N1BXAWv6+RXos4vSAAd7D0XJY=", sig)
+
+	id, sig, err = f.Parse("APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	require.NoError(t, err)
+	require.Equal(t, "me", id)
+	require.Equal(t, "N7N1BXAWv6+RXos4vSAAd7D0XJY=", sig)
+
+	for _, header := range []string{
+		"APIAuth not-base64!",
+		"APIAuth " + base64.StdEncoding.EncodeToString([]byte("nocolon")),
+		"APIAuth " + base64.StdEncoding.EncodeToString([]byte(":nosig")),
+	} {
+		_, _, err = f.Parse(header)
+		require.Equal(t, ErrMalformedHeader, err, header)
+	}
+}
+
+func TestVerifier_EncodedHeader(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth "+base64.StdEncoding.EncodeToString([]byte("me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")))
+
+	require.Equal(t, ErrMalformedHeader, Verify(req, "secret"))
+
+	v := Verifier{Secret: "secret", Format: HeaderFormat{AllowEncoded: true}}
+	require.NoError(t, v.Verify(req))
+}
+
+func TestHeaderFormat_Separator(t *testing.T) {
+	for _, sep := range []string{" ", "."} {
+		f := HeaderFormat{Separator: sep}
+		require.Equal(t, "APIAuth me"+sep+"N7N1BXAWv6+RXos4vSAAd7D0XJY=", f.Header("me", "N7N1BXAWv6+RXos4vSAAd7D0XJY="))
+
+		id, sig, err := f.Parse(f.Header("me", "N7N1BXAWv6+RXos4vSAAd7D0XJY="))
+		require.NoError(t, err, sep)
+		require.Equal(t, "me", id)
+		require.Equal(t, "N7N1BXAWv6+RXos4vSAAd7D0XJY=", sig)
+
+		_, _, err = f.Parse("APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+		require.Equal(t, ErrMalformedHeader, err, sep)
+
+		req, _ := http.NewRequest("GET", "http://example.com/some/path", nil)
+		req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+
+		s := Signer{AccessID: "me", Secret: "secret", Format: f}
+		require.NoError(t, s.Sign(req))
+		require.Equal(t, "APIAuth me"+sep, req.Header.Get("Authorization")[:11])
+
+		v := Verifier{Secret: "secret", Format: f}
+		require.NoError(t, v.Verify(req), sep)
+		require.Equal(t, ErrMalformedHeader, Verify(req, "secret"), sep)
+	}
+}
@@ -0,0 +1,144 @@
+package apiauth
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The defaults of HTTPKeyFunc.
+const (
+	DefaultHTTPKeyTTL         = 5 * time.Minute
+	DefaultHTTPKeyNegativeTTL = 30 * time.Second
+	DefaultHTTPKeyMaxEntries  = 10000
+)
+
+// maxHTTPKeySize caps the size of a secret read from a key service.
+const maxHTTPKeySize = 4096
+
+// An HTTPKeyOption configures the key function HTTPKeyFunc returns.
+type HTTPKeyOption func(k *httpKeyFunc)
+
+// HTTPKeyClient sets the client requests to the key service are made
+// with, such as one whose Transport authenticates them. It defaults to a
+// client with a 10 second timeout. Redirects are never followed, whatever
+// the client's CheckRedirect.
+func HTTPKeyClient(client *http.Client) HTTPKeyOption {
+	return func(k *httpKeyFunc) {
+		k.client = client
+	}
+}
+
+// HTTPKeyTTL sets how long a fetched secret is cached. It defaults to
+// DefaultHTTPKeyTTL. A ttl of zero or less caches secrets until evicted.
+func HTTPKeyTTL(ttl time.Duration) HTTPKeyOption {
+	return func(k *httpKeyFunc) {
+		k.ttl = ttl
+	}
+}
+
+// HTTPKeyNegativeTTL sets how long an access ID the key service does not
+// know is remembered as unknown, so that requests with it are rejected
+// without asking the service again. It defaults to
+// DefaultHTTPKeyNegativeTTL; a ttl of zero or less disables it.
+func HTTPKeyNegativeTTL(ttl time.Duration) HTTPKeyOption {
+	return func(k *httpKeyFunc) {
+		k.negativeTTL = ttl
+	}
+}
+
+// HTTPKeyMaxEntries sets how many access IDs, known or unknown, are
+// cached at most, evicting the least recently used first. It defaults to
+// DefaultHTTPKeyMaxEntries; zero or less means no limit.
+func HTTPKeyMaxEntries(n int) HTTPKeyOption {
+	return func(k *httpKeyFunc) {
+		k.maxEntries = n
+	}
+}
+
+type httpKeyFunc struct {
+	endpoint    string
+	client      *http.Client
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+}
+
+// HTTPKeyFunc returns a KeyFunc fetching secrets from a key service over
+// HTTP, for use with VerifyWithKeyFunc or as a Verifier's KeyFunc. The
+// secret for an access ID is the body, with surrounding whitespace
+// trimmed, of a 200 response to `GET {endpoint}/{accessID}`, with the
+// access ID path-escaped. A 404 response, or an empty secret, fails with
+// ErrUnknownAccessID, as do the access IDs `.` and `..`, which would name
+// another path; other responses, including redirects, which are not
+// followed, and transport errors fail with an error that is not an
+// AuthError, so that servers answer with a 500.
+//
+// Secrets are cached for HTTPKeyTTL and unknown access IDs for
+// HTTPKeyNegativeTTL, in a cache bounded by HTTPKeyMaxEntries. Other
+// failures are not cached. The KeyFunc is safe for concurrent use, and
+// concurrent lookups of an access ID that is not cached share a single
+// request to the service.
+func HTTPKeyFunc(endpoint string, opts ...HTTPKeyOption) KeyFunc {
+	k := &httpKeyFunc{
+		endpoint:    strings.TrimSuffix(endpoint, "/"),
+		ttl:         DefaultHTTPKeyTTL,
+		negativeTTL: DefaultHTTPKeyNegativeTTL,
+		maxEntries:  DefaultHTTPKeyMaxEntries,
+	}
+	for _, opt := range opts {
+		opt(k)
+	}
+
+	// A redirect could lead to a response that is not a secret.
+	client := http.Client{Timeout: 10 * time.Second}
+	if k.client != nil {
+		client = *k.client
+	}
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	k.client = &client
+
+	return newKeyCache(k.fetch, k.ttl, k.negativeTTL, k.maxEntries).get
+}
+
+// fetch requests the secret for an access ID from the key service.
+func (k *httpKeyFunc) fetch(accessID string) (string, error) {
+	switch accessID {
+	case "", ".", "..":
+		return "", ErrUnknownAccessID
+	}
+
+	resp, err := k.client.Get(k.endpoint + "/" + url.PathEscape(accessID))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", ErrUnknownAccessID
+	default:
+		return "", fmt.Errorf("apiauth: key service responded %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHTTPKeySize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > maxHTTPKeySize {
+		return "", fmt.Errorf("apiauth: key service secret longer than %d bytes", maxHTTPKeySize)
+	}
+
+	secret := strings.TrimSpace(string(body))
+	if secret == "" {
+		return "", ErrUnknownAccessID
+	}
+	return secret, nil
+}
@@ -0,0 +1,127 @@
+package apiauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPKeyFunc(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.EscapedPath() {
+		case "/keys/me":
+			w.Write([]byte("secret\n"))
+		case "/keys/slow":
+			<-release
+			w.Write([]byte("slow secret"))
+		case "/keys/a%2Fb":
+			w.Write([]byte("escaped secret"))
+		case "/keys/empty":
+		case "/keys/broken":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	keyFunc := HTTPKeyFunc(server.URL+"/keys/", HTTPKeyClient(server.Client()))
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	require.NoError(t, VerifyWithKeyFunc(req, keyFunc))
+	require.NoError(t, VerifyWithKeyFunc(req, keyFunc))
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	secret, err := keyFunc("a/b")
+	require.NoError(t, err)
+	require.Equal(t, "escaped secret", secret)
+
+	// Unknown access IDs are cached, failures are not.
+	atomic.StoreInt32(&requests, 0)
+	for i := 0; i < 3; i++ {
+		_, err = keyFunc("unknown")
+		require.Equal(t, ErrUnknownAccessID, err)
+		_, err = keyFunc("empty")
+		require.Equal(t, ErrUnknownAccessID, err)
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	for i := 0; i < 2; i++ {
+		_, err = keyFunc("broken")
+		require.EqualError(t, err, "apiauth: key service responded 503 Service Unavailable")
+		require.Equal(t, http.StatusInternalServerError, StatusCode(err))
+	}
+	require.Equal(t, int32(4), atomic.LoadInt32(&requests))
+
+	// Access IDs naming another path are never requested.
+	atomic.StoreInt32(&requests, 0)
+	for _, id := range []string{"", ".", ".."} {
+		_, err = keyFunc(id)
+		require.Equal(t, ErrUnknownAccessID, err, id)
+	}
+	require.Equal(t, int32(0), atomic.LoadInt32(&requests))
+
+	// Concurrent misses share one request.
+	atomic.StoreInt32(&requests, 0)
+	var wg sync.WaitGroup
+	secrets := make(chan string, 10)
+	for i := 0; i < cap(secrets); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			secret, _ := keyFunc("slow")
+			secrets <- secret
+		}()
+	}
+	for atomic.LoadInt32(&requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(secrets)
+
+	for secret := range secrets {
+		require.Equal(t, "slow secret", secret)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestHTTPKeyFunc_Unreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	keyFunc := HTTPKeyFunc(server.URL, HTTPKeyTTL(time.Minute), HTTPKeyNegativeTTL(0), HTTPKeyMaxEntries(1))
+	_, err := keyFunc("me")
+	require.Error(t, err)
+	require.Equal(t, http.StatusInternalServerError, StatusCode(err))
+}
+
+func TestHTTPKeyFunc_Redirect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/keys/me":
+			http.Redirect(w, r, "/public", http.StatusFound)
+		case "/public":
+			w.Write([]byte("ok"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := server.Client()
+	keyFunc := HTTPKeyFunc(server.URL+"/keys", HTTPKeyClient(client))
+	_, err := keyFunc("me")
+	require.EqualError(t, err, "apiauth: key service responded 302 Found")
+	require.Nil(t, client.CheckRedirect)
+}
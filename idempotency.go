@@ -0,0 +1,128 @@
+package apiauth
+
+import (
+	"container/list"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader is the header carrying a request's idempotency key.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// errIdempotencyKeyUnsigned is returned by a Verifier with an
+// IdempotencyStore whose SignedHeaders do not include the key.
+var errIdempotencyKeyUnsigned = errors.New("apiauth: IdempotencyStore set but Idempotency-Key not in SignedHeaders")
+
+// An IdempotencyStore records the idempotency keys of verified requests,
+// so that a Verifier can reject a request reusing one; see
+// Verifier.IdempotencyStore.
+type IdempotencyStore interface {
+	// Seen records the key for the access ID, and reports whether it
+	// was already recorded. It must check and record the key
+	// atomically, so that of concurrent requests with one key only one
+	// is reported unseen.
+	Seen(accessID, key string) (bool, error)
+}
+
+// NewMemoryIdempotencyStore returns an IdempotencyStore holding keys in
+// memory for ttl, after which they may be reused. The ttl should be at
+// least as long as a Verifier accepts a signature for, such as its
+// MaxPast and MaxFuture together, so that a request cannot be replayed
+// once its key is forgotten. Keys are held in a single process, so
+// servers behind a load balancer need a shared store instead. The store
+// is safe for concurrent use.
+func NewMemoryIdempotencyStore(ttl time.Duration) IdempotencyStore {
+	return &memoryIdempotencyStore{
+		ttl:   ttl,
+		now:   time.Now,
+		keys:  make(map[idempotencyKey]*list.Element),
+		order: list.New(),
+	}
+}
+
+type idempotencyKey struct {
+	accessID, key string
+}
+
+type idempotencyEntry struct {
+	key     idempotencyKey
+	expires time.Time
+}
+
+type memoryIdempotencyStore struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu    sync.Mutex
+	keys  map[idempotencyKey]*list.Element
+	order *list.List // oldest first
+}
+
+func (s *memoryIdempotencyStore) Seen(accessID, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	// Entries share a ttl, so they expire in the order they were added.
+	for front := s.order.Front(); front != nil; front = s.order.Front() {
+		entry := front.Value.(*idempotencyEntry)
+		if now.Before(entry.expires) {
+			break
+		}
+		delete(s.keys, entry.key)
+		s.order.Remove(front)
+	}
+
+	k := idempotencyKey{accessID, key}
+	if _, ok := s.keys[k]; ok {
+		return true, nil
+	}
+
+	s.keys[k] = s.order.PushBack(&idempotencyEntry{k, now.Add(s.ttl)})
+	return false, nil
+}
+
+// checkIdempotencyKey records the idempotency key of a request verified
+// under the access ID in the IdempotencyStore, rejecting it if the key
+// was already recorded. Every Verify method calls it once the signature
+// verifies.
+func (v *Verifier) checkIdempotencyKey(r *http.Request, accessID string) error {
+	if v.IdempotencyStore == nil {
+		return nil
+	}
+
+	seen, err := v.IdempotencyStore.Seen(accessID, r.Header.Get(IdempotencyKeyHeader))
+	if err != nil {
+		return err
+	}
+	if seen {
+		return ErrIdempotencyKeyReused
+	}
+	return nil
+}
+
+// checkIdempotencyKeySigned checks that a request carries an idempotency
+// key that its signature covers, when the IdempotencyStore is set.
+func (v *Verifier) checkIdempotencyKeySigned(r *http.Request) error {
+	if v.IdempotencyStore == nil {
+		return nil
+	}
+
+	signed := false
+	for _, name := range v.SignedHeaders {
+		if http.CanonicalHeaderKey(name) == IdempotencyKeyHeader {
+			signed = true
+		}
+	}
+	if !signed {
+		return errIdempotencyKeyUnsigned
+	}
+
+	if r.Header.Get(IdempotencyKeyHeader) == "" {
+		return missingHeader(IdempotencyKeyHeader)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package apiauth
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithIdempotencyStore(t *testing.T) {
+	s, err := NewSigner("me", "secret", WithIdempotencyStore(nil))
+	require.NoError(t, err)
+	v, err := NewVerifier("secret", WithIdempotencyStore(NewMemoryIdempotencyStore(time.Hour)))
+	require.NoError(t, err)
+
+	newRequest := func(key string) *http.Request {
+		body := []byte(`post body`)
+		req, _ := http.NewRequest("POST", "http://example.com/payments", bytes.NewReader(body))
+		req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Content-MD5", base64md5(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		return req
+	}
+
+	first := newRequest("key-1")
+	require.NoError(t, s.Sign(first))
+	require.NoError(t, v.Verify(first))
+	require.Equal(t, ErrIdempotencyKeyReused, v.Verify(first))
+	require.Equal(t, http.StatusConflict, StatusCode(v.Verify(first)))
+
+	// The key is signed, so it cannot be swapped for a fresh one.
+	first.Header.Set(IdempotencyKeyHeader, "key-2")
+	require.Equal(t, ErrSignatureMismatch, v.Verify(first))
+
+	// A failed verification does not use up the key.
+	second := newRequest("key-2")
+	require.NoError(t, s.Sign(second))
+	require.NoError(t, v.Verify(second))
+
+	// Keys are scoped to the access ID.
+	other := newRequest("key-1")
+	s.AccessID = "other"
+	require.NoError(t, s.Sign(other))
+	require.NoError(t, v.Verify(other))
+
+	require.Equal(t, ErrMissingSignedHeader, v.Verify(newRequest("")))
+
+	v2 := newRequest("key-3")
+	require.NoError(t, s.SignV2(v2, HMACSHA256))
+	require.NoError(t, v.VerifyV2(v2))
+	require.Equal(t, ErrIdempotencyKeyReused, v.VerifyV2(v2))
+
+	// The key must be signed for the store to be used.
+	unsigned := Verifier{Secret: "secret", IdempotencyStore: NewMemoryIdempotencyStore(time.Hour)}
+	require.Equal(t, errIdempotencyKeyUnsigned, unsigned.Verify(newRequest("key-4")))
+}
+
+func TestIdempotencyStore_VerifySignatureAndVerifyAll(t *testing.T) {
+	s, err := NewSigner("me", "secret", WithIdempotencyStore(nil))
+	require.NoError(t, err)
+	v, err := NewVerifier("secret", WithIdempotencyStore(NewMemoryIdempotencyStore(time.Hour)))
+	require.NoError(t, err)
+
+	newRequest := func(key string) *http.Request {
+		req, _ := http.NewRequest("GET", "http://example.com/payments", nil)
+		req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+		req.Header.Set(IdempotencyKeyHeader, key)
+		require.NoError(t, s.Sign(req))
+		return req
+	}
+
+	req := newRequest("key-1")
+	sig, err := ParseSignature(req.Header.Get("Authorization"))
+	require.NoError(t, err)
+	require.NoError(t, v.VerifySignature(req, sig))
+	require.Equal(t, ErrIdempotencyKeyReused, v.VerifySignature(req, sig))
+
+	var failures []string
+	v.OnFailure = func(reason string) { failures = append(failures, reason) }
+
+	req = newRequest("key-2")
+	require.NoError(t, v.VerifyAll(req))
+	require.Equal(t, ErrIdempotencyKeyReused, v.VerifyAll(req))
+	require.Equal(t, ErrIdempotencyKeyReused, v.Verify(req))
+	require.Equal(t, []string{"idempotency_key_reused", "idempotency_key_reused"}, failures)
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	now := time.Date(2015, time.March, 20, 19, 37, 40, 0, time.UTC)
+	s := NewMemoryIdempotencyStore(time.Minute).(*memoryIdempotencyStore)
+	s.now = func() time.Time { return now }
+
+	seen, err := s.Seen("me", "a")
+	require.NoError(t, err)
+	require.False(t, seen)
+
+	seen, _ = s.Seen("me", "a")
+	require.True(t, seen)
+
+	now = now.Add(30 * time.Second)
+	seen, _ = s.Seen("me", "b")
+	require.False(t, seen)
+
+	now = now.Add(30 * time.Second)
+	seen, _ = s.Seen("me", "a")
+	require.False(t, seen)
+	seen, _ = s.Seen("me", "b")
+	require.True(t, seen)
+
+	require.Len(t, s.keys, 2)
+	require.Equal(t, 2, s.order.Len())
+}
@@ -0,0 +1,240 @@
+package apiauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// A KeyProvider supplies keyed MACs for verifying signatures, so that a
+// Verifier need never hold the raw secret, e.g. when keys are derived at
+// provisioning time or held by a secure enclave.
+type KeyProvider interface {
+	// NewMAC returns a fresh MAC, keyed as hmac.New(sha1.New, secret)
+	// would be for the client's secret.
+	NewMAC() hash.Hash
+}
+
+// errKeyProviderUnsupported is returned for signatures that a Verifier
+// whose only key source is a KeyProvider cannot check, as they are not
+// HMAC-SHA1 over the canonical string.
+var errKeyProviderUnsupported = errors.New("apiauth: KeyProvider cannot verify V2 or message signatures")
+
+// MACFunc adapts a function returning fresh keyed MACs to a KeyProvider.
+type MACFunc func() hash.Hash
+
+// NewMAC calls f.
+func (f MACFunc) NewMAC() hash.Hash {
+	return f()
+}
+
+// A KeyFunc returns the secret for an access ID, for servers that verify
+// requests from many clients. It should return an error for unknown
+// access IDs.
+type KeyFunc func(accessID string) (string, error)
+
+// A SecretFunc is a KeyFunc that looks up secrets when each request is
+// verified, rather than once at startup, so that secrets held in the
+// environment or a secret store can be set after the Verifier is
+// created, and rotated without restarting. Set it as a Verifier's
+// KeyFunc. For example, to read a single client's secret from the
+// environment:
+//
+//	v := apiauth.Verifier{AccessID: "web", KeyFunc: func(string) (string, error) {
+//		return os.Getenv("API_SECRET"), nil
+//	}}
+//
+// See EnvSecretFunc for clients with a variable each.
+type SecretFunc = KeyFunc
+
+// EnvSecretFunc returns a SecretFunc reading the secret for each access
+// ID from the environment variable named by prefix followed by the access
+// ID, uppercased and with any character other than an ASCII letter,
+// digit or underscore replaced by an underscore. For example, with
+// prefix "APIAUTH_SECRET_" the secret for "web-client" is read from
+// APIAUTH_SECRET_WEB_CLIENT. Access IDs whose variable is unset or empty
+// fail with ErrUnknownAccessID. Since distinct access IDs may map to the
+// same variable, give each client an ID that does not collide.
+//
+// Every variable whose name starts with prefix is treated as a secret,
+// that a client can select by its access ID, so the prefix must not be
+// shared with variables holding anything else. EnvSecretFunc panics if
+// prefix is empty, which would let clients pick any variable, such as
+// HOME.
+func EnvSecretFunc(prefix string) SecretFunc {
+	if prefix == "" {
+		panic("apiauth: EnvSecretFunc prefix is empty")
+	}
+
+	return func(accessID string) (string, error) {
+		if accessID == "" {
+			return "", ErrUnknownAccessID
+		}
+
+		secret := os.Getenv(prefix + envName(accessID))
+		if secret == "" {
+			return "", ErrUnknownAccessID
+		}
+		return secret, nil
+	}
+}
+
+// envName returns the access ID as it appears in environment variable
+// names.
+func envName(accessID string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, accessID)
+}
+
+// A VersionedSecret is one version of a rotating secret, valid for
+// requests signed between NotBefore and NotAfter inclusive. A zero
+// NotBefore or NotAfter leaves that end of the window open.
+type VersionedSecret struct {
+	Secret    string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+func (s VersionedSecret) validAt(t time.Time) bool {
+	if !s.NotBefore.IsZero() && t.Before(s.NotBefore) {
+		return false
+	}
+	if !s.NotAfter.IsZero() && t.After(s.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// VerifyWithHasher checks a request as in Verify, computing signatures
+// with the keyed MACs returned by newMAC in place of a secret.
+func VerifyWithHasher(r *http.Request, newMAC func() hash.Hash) error {
+	v := Verifier{KeyProvider: MACFunc(newMAC)}
+	return v.Verify(r)
+}
+
+// VerifyWithKeyFunc checks a request as in Verify, using the secret
+// keyFunc returns for the request's access ID, for servers that verify
+// requests from many clients. Errors from keyFunc are returned as they
+// are.
+func VerifyWithKeyFunc(r *http.Request, keyFunc func(id string) (string, error)) error {
+	v := Verifier{KeyFunc: keyFunc}
+	return v.Verify(r)
+}
+
+// VerifyPrimaryFallback checks a request as in Verify with the primary
+// secret and, only if its signature does not match, with the secret
+// fallback returns, for migrating secrets to a new format without
+// deriving or fetching the other one for every request. Any other
+// failure with the primary secret is returned without calling fallback.
+// Each signature is compared in constant time, though whether the
+// fallback was needed may show in the time taken. Errors from fallback
+// are returned as they are.
+func VerifyPrimaryFallback(r *http.Request, primary string, fallback func() (string, error)) error {
+	err := Verify(r, primary)
+	if err != ErrSignatureMismatch || fallback == nil {
+		return err
+	}
+
+	secret, err := fallback()
+	if err != nil {
+		return err
+	}
+	return Verify(r, secret)
+}
+
+// VerifyWithDatedKeyFunc checks a request as in Verify, using the secret
+// keyFunc returns for the request's access ID and the time in its Date
+// header, for keys that rotate daily or on another schedule. Errors from
+// keyFunc are returned as they are.
+func VerifyWithDatedKeyFunc(r *http.Request, keyFunc func(accessID string, signedTime time.Time) (string, error)) error {
+	v := Verifier{DatedKeyFunc: keyFunc}
+	return v.Verify(r)
+}
+
+// VerifyWithVersionedKeys checks a request as in Verify, trying each
+// version of the secret keyFunc returns for the request's access ID
+// whose validity window contains the time in its Date header, for keys
+// that rotate with overlapping windows. Requests signed when no version
+// was valid fail with ErrSignatureMismatch. Errors from keyFunc are
+// returned as they are.
+func VerifyWithVersionedKeys(r *http.Request, keyFunc func(accessID string) ([]VersionedSecret, error)) error {
+	v := Verifier{VersionedKeyFunc: keyFunc}
+	return v.Verify(r)
+}
+
+// A SigningKeyFunc derives the key used to compute signatures from a
+// secret and the time a request was signed, so that the secret itself is
+// never used directly as a MAC key and a leaked signing key is only good
+// for a limited time. The client and server must use the same one.
+type SigningKeyFunc func(secret string, signedTime time.Time) []byte
+
+// ScopedSigningKey returns a SigningKeyFunc deriving keys as AWS Signature
+// Version 4 does: the secret keys an HMAC-SHA256 of the signing date, as
+// YYYYMMDD in UTC, whose result keys an HMAC-SHA256 of the first scope,
+// and so on through each scope in turn. For example,
+// ScopedSigningKey("us-east-1", "orders", "apiauth_request") mirrors
+// SigV4's chain of date, region, service and terminator.
+func ScopedSigningKey(scopes ...string) SigningKeyFunc {
+	return func(secret string, signedTime time.Time) []byte {
+		key := hmacSHA256([]byte(secret), signedTime.UTC().Format("20060102"))
+		for _, scope := range scopes {
+			key = hmacSHA256(key, scope)
+		}
+		return key
+	}
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signingKey returns the key derived from secret by f, or the secret
+// itself if f is nil.
+func signingKey(f SigningKeyFunc, secret string, signedTime time.Time) []byte {
+	if f == nil {
+		return []byte(secret)
+	}
+	return f(secret, signedTime)
+}
+
+// secretMAC returns a function creating HMAC-SHA1 MACs keyed by secret.
+func secretMAC(secret string) func() hash.Hash {
+	return keyMAC([]byte(secret))
+}
+
+// keyMAC returns a function creating HMAC-SHA1 MACs keyed by key.
+func keyMAC(key []byte) func() hash.Hash {
+	return func() hash.Hash {
+		return hmac.New(sha1.New, key)
+	}
+}
+
+// verifyMAC reports whether sig is the MAC of the canonical string in the
+// given encoding, comparing the raw MACs in constant time.
+func verifyMAC(sig, canonicalString string, newMAC func() hash.Hash, enc SignatureEncoding) bool {
+	mac := newMAC()
+	mac.Write([]byte(canonicalString))
+	expected := mac.Sum(nil)
+
+	decoded, err := enc.Decode(sig)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(expected, decoded)
+}
@@ -0,0 +1,237 @@
+package apiauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyWithHasher(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+
+	key := []byte("secret")
+	newMAC := func() hash.Hash { return hmac.New(sha1.New, key) }
+	require.NoError(t, VerifyWithHasher(req, newMAC))
+
+	wrong := func() hash.Hash { return hmac.New(sha1.New, []byte("other")) }
+	require.Equal(t, ErrSignatureMismatch, VerifyWithHasher(req, wrong))
+}
+
+func TestVerifier_KeyProvider(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com/some/path?x=1&b=2", nil)
+	req.Header.Add("Content-Type", "text/plain")
+	req.Header.Add("Content-MD5", "WnNni3tnQAUFZDSkgFRwfQ==")
+	req.Header.Add("Date", "Thu, 19 Mar 2015 19:24:24 GMT")
+	req.Header.Add("Authorization", `APIAuth me:43DQKYwiMx3swEwa3raDq5tPxIo=`)
+
+	calls := 0
+	v := Verifier{KeyProvider: MACFunc(func() hash.Hash {
+		calls++
+		return hmac.New(sha1.New, []byte("secret"))
+	})}
+	require.NoError(t, v.Verify(req))
+	require.Equal(t, 1, calls)
+}
+
+func TestVerifyWithDatedKeyFunc(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+
+	keys := map[string]string{"2015-03-20": "secret", "2015-03-21": "other"}
+	keyFunc := func(accessID string, signedTime time.Time) (string, error) {
+		require.Equal(t, "me", accessID)
+		key, ok := keys[signedTime.UTC().Format("2006-01-02")]
+		if !ok {
+			return "", errUnknownKey
+		}
+		return key, nil
+	}
+	require.NoError(t, VerifyWithDatedKeyFunc(req, keyFunc))
+
+	// The next day's key does not verify it.
+	keys["2015-03-20"] = "other"
+	require.Equal(t, ErrSignatureMismatch, VerifyWithDatedKeyFunc(req, keyFunc))
+
+	delete(keys, "2015-03-20")
+	require.Equal(t, errUnknownKey, VerifyWithDatedKeyFunc(req, keyFunc))
+
+	req.Header.Set("Date", "yesterday")
+	require.Equal(t, ErrInvalidDate, VerifyWithDatedKeyFunc(req, keyFunc))
+}
+
+var errUnknownKey = errors.New("unknown key")
+
+func TestScopedSigningKey(t *testing.T) {
+	// The signing key derivation example from the AWS Signature Version 4
+	// documentation, which prefixes the secret with "AWS4".
+	f := ScopedSigningKey("us-east-1", "iam", "aws4_request")
+	key := f("AWS4wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", time.Date(2015, time.August, 30, 12, 36, 0, 0, time.UTC))
+	require.Equal(t, "c4afb1cc5771d871763a393e44b703571b55cc28424d1a5e86da6ed3c154a4b9", hex.EncodeToString(key))
+}
+
+func TestSigningKey(t *testing.T) {
+	newRequest := func(date string) *http.Request {
+		req, _ := http.NewRequest("GET", "http://example.com/some/path", nil)
+		req.Header.Set("Date", date)
+		return req
+	}
+
+	f := ScopedSigningKey("us-east-1", "orders", "apiauth_request")
+	s := Signer{AccessID: "me", Secret: "secret", WithMethod: true, SigningKey: f}
+	v := Verifier{Secret: "secret", SigningKey: f}
+
+	req := newRequest("Fri, 20 Mar 2015 19:37:40 GMT")
+	require.NoError(t, s.Sign(req))
+	require.NoError(t, v.Verify(req))
+
+	// The raw secret does not verify it.
+	require.Equal(t, ErrSignatureMismatch, Verify(req, "secret"))
+
+	// Nor does the key for another day.
+	mac := hmac.New(sha1.New, f("secret", time.Date(2015, time.March, 21, 0, 0, 0, 0, time.UTC)))
+	mac.Write([]byte(CanonicalStringWithMethod(req)))
+	req.Header.Set("Authorization", "APIAuth me:"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	require.Equal(t, ErrSignatureMismatch, v.Verify(req))
+
+	req = newRequest("yesterday")
+	require.Equal(t, ErrInvalidDate, s.Sign(req))
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	require.Equal(t, ErrInvalidDate, v.Verify(req))
+
+	// The V2 scheme derives the key from its timestamp.
+	req, _ = http.NewRequest("GET", "http://example.com/some/path", nil)
+	require.NoError(t, s.SignV2(req, HMACSHA256))
+	require.NoError(t, v.VerifyV2(req))
+	require.Equal(t, ErrSignatureMismatch, VerifyV2(req, v2Keys))
+}
+
+func TestEnvSecretFunc(t *testing.T) {
+	const name = "APIAUTH_TEST_SECRET_WEB_CLIENT_1"
+	os.Unsetenv(name)
+	defer os.Unsetenv(name)
+
+	v := Verifier{KeyFunc: EnvSecretFunc("APIAUTH_TEST_SECRET_")}
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.NoError(t, SignWithMethod(req, "web-client.1", "secret"))
+
+	// The variable is read when the request is verified, not when the
+	// Verifier is created.
+	require.Equal(t, ErrUnknownAccessID, v.Verify(req))
+
+	os.Setenv(name, "secret")
+	require.NoError(t, v.Verify(req))
+
+	os.Setenv(name, "rotated")
+	require.Equal(t, ErrSignatureMismatch, v.Verify(req))
+
+	secret, err := EnvSecretFunc("APIAUTH_TEST_SECRET_")("")
+	require.Equal(t, ErrUnknownAccessID, err)
+	require.Equal(t, "", secret)
+
+	require.Panics(t, func() { EnvSecretFunc("") })
+}
+
+func TestVerifyWithVersionedKeys(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2015, time.March, d, 0, 0, 0, 0, time.UTC) }
+	versions := []VersionedSecret{
+		{Secret: "v1", NotAfter: day(10)},
+		{Secret: "v2", NotBefore: day(9), NotAfter: day(20)},
+		{Secret: "v3", NotBefore: day(19)},
+	}
+
+	calls := 0
+	keyFunc := func(accessID string) ([]VersionedSecret, error) {
+		calls++
+		if accessID != "me" {
+			return nil, errUnknownKey
+		}
+		return versions, nil
+	}
+
+	verify := func(signed time.Time, secret string) error {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		req.Header.Set("Date", DateForTime(signed))
+		require.NoError(t, SignWithMethod(req, "me", secret))
+		return VerifyWithVersionedKeys(req, keyFunc)
+	}
+
+	// Each secret verifies within its window, including both bounds.
+	require.NoError(t, verify(day(1), "v1"))
+	require.NoError(t, verify(day(10), "v1"))
+	require.NoError(t, verify(day(9), "v2"))
+	require.NoError(t, verify(day(20), "v2"))
+	require.NoError(t, verify(day(19), "v3"))
+	require.NoError(t, verify(day(30), "v3"))
+
+	// And not outside it.
+	require.Equal(t, ErrSignatureMismatch, verify(day(10).Add(time.Second), "v1"))
+	require.Equal(t, ErrSignatureMismatch, verify(day(9).Add(-time.Second), "v2"))
+	require.Equal(t, ErrSignatureMismatch, verify(day(20).Add(time.Second), "v2"))
+	require.Equal(t, ErrSignatureMismatch, verify(day(19).Add(-time.Second), "v3"))
+	require.Equal(t, ErrSignatureMismatch, verify(day(15), "other"))
+
+	// No version valid at the signed time.
+	versions = versions[1:2]
+	require.Equal(t, ErrSignatureMismatch, verify(day(1), "v1"))
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Date", DateForTime(day(15)))
+	require.NoError(t, SignWithMethod(req, "you", "v2"))
+	require.Equal(t, errUnknownKey, VerifyWithVersionedKeys(req, keyFunc))
+
+	req.Header.Set("Date", "yesterday")
+	calls = 0
+	require.Equal(t, ErrInvalidDate, VerifyWithVersionedKeys(req, keyFunc))
+	require.Equal(t, 0, calls)
+
+	// V2 signatures are checked against their timestamp.
+	v := Verifier{VersionedKeyFunc: keyFunc, Now: func() time.Time { return day(15) }}
+	s := Signer{AccessID: "me", Secret: "v2", ClockOffset: day(15).Sub(time.Now())}
+	req, _ = http.NewRequest("GET", "http://example.com/", nil)
+	require.NoError(t, s.SignV2(req, HMACSHA256))
+	require.NoError(t, v.VerifyV2(req))
+}
+
+func TestVerifyPrimaryFallback(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+
+	calls := 0
+	fallback := func(secret string, err error) func() (string, error) {
+		return func() (string, error) {
+			calls++
+			return secret, err
+		}
+	}
+
+	require.NoError(t, VerifyPrimaryFallback(req, "secret", fallback("other", nil)))
+	require.Equal(t, 0, calls)
+
+	require.NoError(t, VerifyPrimaryFallback(req, "old", fallback("secret", nil)))
+	require.Equal(t, 1, calls)
+
+	require.Equal(t, ErrSignatureMismatch, VerifyPrimaryFallback(req, "old", fallback("other", nil)))
+	require.Equal(t, errUnknownKey, VerifyPrimaryFallback(req, "old", fallback("", errUnknownKey)))
+	require.Equal(t, ErrSignatureMismatch, VerifyPrimaryFallback(req, "old", nil))
+
+	// Failures other than a mismatch do not call the fallback.
+	calls = 0
+	req.Header.Del("Date")
+	require.Equal(t, ErrMissingDate, VerifyPrimaryFallback(req, "old", fallback("secret", nil)))
+	require.Equal(t, 0, calls)
+}
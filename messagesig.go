@@ -0,0 +1,393 @@
+package apiauth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MessageSignatureAlgorithm is the only algorithm VerifyMessageSignature
+// accepts, as named in the alg parameter of Signature-Input.
+const MessageSignatureAlgorithm = "hmac-sha256"
+
+// VerifyMessageSignature checks a request signed as HTTP Message
+// Signatures (RFC 9421) specifies, in its Signature and Signature-Input
+// headers, in place of an Authorization header, for clients migrating
+// from that scheme. Only the subset of RFC 9421 used with this package is
+// supported:
+//
+//   - The signature is HMAC-SHA256, keyed by the secret keyFunc returns
+//     for the keyid parameter. The alg parameter, if present, must be
+//     hmac-sha256.
+//   - The created parameter is required, and is checked as the V2
+//     timestamp is, against DefaultMaxPast and DefaultMaxFuture. An
+//     expires parameter, if present, must not have passed, or
+//     ErrExpired is returned.
+//   - The covered components may be header fields and the @method,
+//     @authority, @path, @query and @request-target derived components,
+//     without component parameters. They must include at least @method
+//     and @request-target and, for requests with a body, content-type and
+//     content-md5, so that a signature covers as much as the
+//     Authorization scheme's would.
+//
+// Only the first signature in Signature-Input is checked. Errors from
+// keyFunc are returned as they are, and an empty secret verifies
+// nothing.
+func VerifyMessageSignature(r *http.Request, keyFunc KeyFunc) error {
+	v := Verifier{KeyFunc: keyFunc}
+	return v.VerifyMessageSignature(r)
+}
+
+// VerifyMessageSignature checks a request signed with HTTP Message
+// Signatures, as the package-level VerifyMessageSignature does, honoring
+// the Verifier's options as VerifyV2 does. The integrity header that must
+// be covered is IntegrityHeader, and each of SignedHeaders must be
+// covered as well.
+func (v *Verifier) VerifyMessageSignature(r *http.Request) error {
+	start := time.Now()
+	id, err := v.verifyMessageSignature(r)
+	v.report(start, id, 0, err)
+	return v.annotate(r, err)
+}
+
+// A messageSignature is a signature parsed from the Signature and
+// Signature-Input headers.
+type messageSignature struct {
+	components []string
+	params     string
+	keyID      string
+	alg        string
+	created    time.Time
+	expires    time.Time
+	signature  []byte
+}
+
+func (v *Verifier) verifyMessageSignature(r *http.Request) (id string, err error) {
+	if err := v.checkHeadersV2(r); err != nil {
+		return "", err
+	}
+
+	if r.Header.Get("Signature-Input") == "" || r.Header.Get("Signature") == "" {
+		return "", ErrMissingAuthorization
+	}
+
+	sig, err := parseMessageSignature(r.Header)
+	if err != nil {
+		return "", err
+	}
+
+	if err := v.checkCoverage(r, sig.components); err != nil {
+		return sig.keyID, err
+	}
+
+	maxPast := v.MaxPast
+	if maxPast <= 0 {
+		maxPast = DefaultMaxPast
+	}
+
+	if err := v.checkTime(sig.created, maxPast); err != nil {
+		return sig.keyID, err
+	}
+
+	if !sig.expires.IsZero() && v.now().After(sig.expires) {
+		return sig.keyID, ErrExpired
+	}
+
+	base, err := v.signatureBase(v.signedRequest(r), sig)
+	if err != nil {
+		return sig.keyID, err
+	}
+
+	if err := v.checkAccessID(sig.keyID); err != nil {
+		return sig.keyID, err
+	}
+
+	secrets, err := v.keys(sig.keyID, sig.created)
+	if err != nil {
+		return sig.keyID, err
+	}
+
+	verified := false
+	encoded := base64.StdEncoding.EncodeToString(sig.signature)
+	for _, secret := range secrets {
+		newMAC, _ := HMACSHA256.newMAC(signingKey(v.SigningKey, secret, sig.created))
+		if verifyMAC(encoded, base, newMAC, Base64) {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return sig.keyID, ErrSignatureMismatch
+	}
+
+	if v.AccessID != "" && subtle.ConstantTimeCompare([]byte(sig.keyID), []byte(v.AccessID)) != 1 {
+		return sig.keyID, ErrAccessIDMismatch
+	}
+
+	return sig.keyID, v.checkIdempotencyKey(r, sig.keyID)
+}
+
+// checkCoverage checks that the covered components include those the
+// Authorization scheme would sign.
+func (v *Verifier) checkCoverage(r *http.Request, components []string) error {
+	required := []string{"@method", "@request-target"}
+	if v.IncludeHost {
+		required = append(required, "@authority")
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		if !v.OptionalContentType {
+			required = append(required, "content-type")
+		}
+		required = append(required, strings.ToLower(v.integrityHeader()))
+	}
+	for _, name := range v.SignedHeaders {
+		required = append(required, strings.ToLower(name))
+	}
+
+	covered := make(map[string]bool, len(components))
+	for _, component := range components {
+		covered[component] = true
+	}
+
+	for _, component := range required {
+		if !covered[component] {
+			return ErrUncoveredComponent
+		}
+	}
+	return nil
+}
+
+// signatureBase returns the string RFC 9421 signs for the request.
+func (v *Verifier) signatureBase(r *http.Request, sig messageSignature) (string, error) {
+	var b strings.Builder
+	for _, component := range sig.components {
+		value, err := v.componentValue(r, component)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(`"` + component + `": ` + value + "\n")
+	}
+
+	b.WriteString(`"@signature-params": ` + sig.params)
+	return b.String(), nil
+}
+
+// componentValue returns the value of a covered component.
+func (v *Verifier) componentValue(r *http.Request, component string) (string, error) {
+	switch component {
+	case "@method":
+		return r.Method, nil
+	case "@authority":
+		return v.host(r), nil
+	case "@path":
+		return requestPath(r), nil
+	case "@query":
+		return "?" + requestQuery(r), nil
+	case "@request-target":
+		if query := requestQuery(r); query != "" {
+			return requestPath(r) + "?" + query, nil
+		}
+		return requestPath(r), nil
+	}
+
+	if strings.HasPrefix(component, "@") {
+		return "", ErrMalformedHeader
+	}
+
+	if component == "host" && r.Host != "" {
+		return r.Host, nil
+	}
+
+	values := r.Header[http.CanonicalHeaderKey(component)]
+	if len(values) == 0 {
+		return "", ErrMissingSignedHeader
+	}
+
+	trimmed := make([]string, len(values))
+	for i, value := range values {
+		trimmed[i] = strings.TrimSpace(value)
+	}
+	return strings.Join(trimmed, ", "), nil
+}
+
+func requestPath(r *http.Request) string {
+	if r.URL == nil || r.URL.EscapedPath() == "" {
+		return "/"
+	}
+	return r.URL.EscapedPath()
+}
+
+func requestQuery(r *http.Request) string {
+	if r.URL == nil {
+		return ""
+	}
+	return r.URL.RawQuery
+}
+
+// parseMessageSignature parses the first signature in the
+// Signature-Input header, and its value from the Signature header.
+func parseMessageSignature(h http.Header) (messageSignature, error) {
+	var sig messageSignature
+
+	inputs := splitMembers(strings.Join(h["Signature-Input"], ","))
+	label, input, ok := splitMember(inputs[0])
+	if !ok || !strings.HasPrefix(input, "(") {
+		return sig, ErrMalformedHeader
+	}
+	sig.params = input
+
+	end := strings.IndexByte(input, ')')
+	if end < 0 {
+		return sig, ErrMalformedHeader
+	}
+
+	seen := map[string]bool{}
+	for _, item := range strings.Fields(input[1:end]) {
+		if len(item) < 2 || item[0] != '"' || item[len(item)-1] != '"' || seen[item] {
+			return sig, ErrMalformedHeader
+		}
+		seen[item] = true
+		sig.components = append(sig.components, item[1:len(item)-1])
+	}
+
+	for _, param := range splitParams(input[end+1:]) {
+		tokens := strings.SplitN(param, "=", 2)
+		if len(tokens) != 2 {
+			return sig, ErrMalformedHeader
+		}
+
+		var err error
+		switch tokens[0] {
+		case "keyid":
+			sig.keyID, err = unquote(tokens[1])
+		case "alg":
+			sig.alg, err = unquote(tokens[1])
+		case "created":
+			sig.created, err = parseUnix(tokens[1])
+		case "expires":
+			sig.expires, err = parseUnix(tokens[1])
+		}
+		if err != nil {
+			return sig, err
+		}
+	}
+
+	if sig.keyID == "" || sig.created.IsZero() {
+		return sig, ErrMalformedHeader
+	}
+
+	if sig.alg != "" && sig.alg != MessageSignatureAlgorithm {
+		return sig, ErrUnsupportedAlgorithm
+	}
+
+	for _, member := range splitMembers(strings.Join(h["Signature"], ",")) {
+		name, value, ok := splitMember(member)
+		if !ok || name != label {
+			continue
+		}
+
+		if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			return sig, ErrMalformedHeader
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+		if err != nil {
+			return sig, ErrMalformedHeader
+		}
+		sig.signature = decoded
+		return sig, nil
+	}
+
+	return sig, ErrMalformedHeader
+}
+
+// splitMembers splits a structured field dictionary into its members,
+// ignoring commas in quoted strings and inner lists.
+func splitMembers(field string) []string {
+	var members []string
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(field); i++ {
+		switch c := field[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			members = append(members, strings.TrimSpace(field[start:i]))
+			start = i + 1
+		}
+	}
+	return append(members, strings.TrimSpace(field[start:]))
+}
+
+// splitMember splits a dictionary member into its label and value.
+func splitMember(member string) (label, value string, ok bool) {
+	i := strings.IndexByte(member, '=')
+	if i <= 0 {
+		return "", "", false
+	}
+	return member[:i], member[i+1:], true
+}
+
+// splitParams splits the `;key=value` parameters following an item,
+// ignoring semicolons in quoted strings.
+func splitParams(params string) []string {
+	var split []string
+	quoted, start := false, -1
+	for i := 0; i < len(params); i++ {
+		switch c := params[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == ';' && !quoted:
+			if start >= 0 {
+				split = append(split, params[start:i])
+			}
+			start = i + 1
+		}
+	}
+	if start >= 0 {
+		split = append(split, params[start:])
+	}
+	return split
+}
+
+// unquote returns the value of a structured field string.
+func unquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", ErrMalformedHeader
+	}
+
+	s = s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			if i == len(s) {
+				return "", ErrMalformedHeader
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), nil
+}
+
+// parseUnix parses a structured field integer of seconds since the Unix
+// epoch.
+func parseUnix(s string) (time.Time, error) {
+	unix, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || unix <= 0 {
+		return time.Time{}, ErrMalformedHeader
+	}
+	return time.Unix(unix, 0), nil
+}
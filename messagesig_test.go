@@ -0,0 +1,119 @@
+package apiauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func messageSignatureRequest(input, signature string) *http.Request {
+	req, _ := http.NewRequest("POST", "http://example.com/foo?param=Value&Pet=dog", strings.NewReader(`{"hello": "world"}`))
+	req.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-MD5", "Sd/dVLAcvNLSq16eXua5uQ==")
+	req.Header.Set("Signature-Input", input)
+	req.Header.Set("Signature", signature)
+	return req
+}
+
+func TestSignatureBase(t *testing.T) {
+	// The HMAC-SHA256 example from RFC 9421, Appendix B.2.5.
+	req := messageSignatureRequest(
+		`sig-b25=("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`,
+		`sig-b25=:pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=:`,
+	)
+
+	sig, err := parseMessageSignature(req.Header)
+	require.NoError(t, err)
+	require.Equal(t, "test-shared-secret", sig.keyID)
+	require.Equal(t, []string{"date", "@authority", "content-type"}, sig.components)
+
+	base, err := (&Verifier{}).signatureBase(req, sig)
+	require.NoError(t, err)
+	require.Equal(t, `"date": Tue, 20 Apr 2021 02:07:55 GMT
+"@authority": example.com
+"content-type": application/json
+"@signature-params": ("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`, base)
+
+	key, _ := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(base))
+	require.Equal(t, sig.signature, mac.Sum(nil))
+}
+
+func TestVerifyMessageSignature(t *testing.T) {
+	const input = `sig1=("@method" "@request-target" "content-type" "content-md5");created=1618884473;keyid="me";alg="hmac-sha256"`
+	const signature = `sig1=:uOR/2pUAjntbexbwzi12k/NQb2/pdmVghlONg57jOcE=:`
+
+	created := time.Unix(1618884473, 0)
+	v := Verifier{KeyFunc: v2Keys, Now: func() time.Time { return created.Add(time.Minute) }}
+	require.NoError(t, v.VerifyMessageSignature(messageSignatureRequest(input, signature)))
+
+	// The label picks the matching signature.
+	req := messageSignatureRequest(input, `other=:AAAA:, `+signature)
+	require.NoError(t, v.VerifyMessageSignature(req))
+
+	req = messageSignatureRequest(input, signature)
+	req.Method = "PUT"
+	require.Equal(t, ErrSignatureMismatch, v.VerifyMessageSignature(req))
+
+	for in, want := range map[string]error{
+		`sig1=("@method" "@request-target" "content-type");created=1618884473;keyid="me"`:                                    ErrUncoveredComponent,
+		`sig1=("@method" "content-type" "content-md5");created=1618884473;keyid="me"`:                                        ErrUncoveredComponent,
+		`sig1=("@method" "@request-target" "content-type" "content-md5");created=1618884473;keyid="you"`:                     errUnknownKey,
+		`sig1=("@method" "@request-target" "content-type" "content-md5");created=1618884473;keyid="me"`:                      ErrSignatureMismatch,
+		`sig1=("@method" "@request-target" "content-type" "content-md5");created=1618880000;keyid="me"`:                      ErrDateTooOld,
+		`sig1=("@method" "@request-target" "content-type" "content-md5");created=1618884473;expires=1618884500;keyid="me"`:   ErrExpired,
+		`sig1=("@method" "@request-target" "content-type" "content-md5");created=1618884473;keyid="me";alg="rsa-pss-sha512"`: ErrUnsupportedAlgorithm,
+		`sig1=("@method" "@request-target" "content-type" "content-md5" "@status");created=1618884473;keyid="me"`:            ErrMalformedHeader,
+		`sig1=("@method" "@request-target" "content-type" "content-md5" "accept");created=1618884473;keyid="me"`:             ErrMissingSignedHeader,
+		`sig1=("@method" "@method" "@request-target" "content-type" "content-md5");created=1618884473;keyid="me"`:            ErrMalformedHeader,
+		`sig1=("@method" "@request-target" "content-type" "content-md5");keyid="me"`:                                         ErrMalformedHeader,
+		`sig1=("@method" "@request-target" "content-type" "content-md5");created=1618884473`:                                 ErrMalformedHeader,
+		`sig2=("@method" "@request-target" "content-type" "content-md5");created=1618884473;keyid="me"`:                      ErrMalformedHeader,
+		`sig1="@method"`: ErrMalformedHeader,
+	} {
+		require.Equal(t, want, v.VerifyMessageSignature(messageSignatureRequest(in, signature)), in)
+	}
+
+	req = messageSignatureRequest(input, signature)
+	req.Header.Del("Signature")
+	require.Equal(t, ErrMissingAuthorization, v.VerifyMessageSignature(req))
+
+	v.AccessID = "you"
+	require.Equal(t, ErrAccessIDMismatch, v.VerifyMessageSignature(messageSignatureRequest(input, signature)))
+
+	// The package-level function checks created against the current time.
+	require.Equal(t, ErrDateTooOld, VerifyMessageSignature(messageSignatureRequest(input, signature), v2Keys))
+}
+
+func TestVerifyMessageSignature_EmptyKey(t *testing.T) {
+	const input = `sig1=("@method" "@request-target" "content-type" "content-md5");created=1618884473;keyid="me"`
+	created := time.Unix(1618884473, 0)
+
+	// A signature forged with an empty key.
+	req := messageSignatureRequest(input, "sig1=:AAAA:")
+	sig, err := parseMessageSignature(req.Header)
+	require.NoError(t, err)
+	base, err := (&Verifier{}).signatureBase(req, sig)
+	require.NoError(t, err)
+	mac := hmac.New(sha256.New, nil)
+	mac.Write([]byte(base))
+	forged := "sig1=:" + base64.StdEncoding.EncodeToString(mac.Sum(nil)) + ":"
+
+	now := func() time.Time { return created.Add(time.Minute) }
+	provider := Verifier{KeyProvider: MACFunc(func() hash.Hash { return hmac.New(sha1.New, []byte("secret")) }), Now: now}
+	require.Equal(t, errKeyProviderUnsupported, provider.VerifyMessageSignature(messageSignatureRequest(input, forged)))
+
+	empty := Verifier{KeyFunc: func(string) (string, error) { return "", nil }, Now: now}
+	require.Equal(t, ErrSignatureMismatch, empty.VerifyMessageSignature(messageSignatureRequest(input, forged)))
+	require.Equal(t, ErrSignatureMismatch, (&Verifier{Now: now}).VerifyMessageSignature(messageSignatureRequest(input, forged)))
+}
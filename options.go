@@ -0,0 +1,208 @@
+package apiauth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrWeakSecret is returned by NewSigner and NewVerifier for a secret
+// that WithMinSecretLength rejects.
+var ErrWeakSecret = errors.New("apiauth: secret too weak")
+
+// An Option configures a Signer or Verifier built by NewSigner or
+// NewVerifier. Give the client and server the same options.
+type Option func(o *options) error
+
+type options struct {
+	Canonicalizer
+	minSecretLength     int
+	idempotencyStore    IdempotencyStore
+	authorizationHeader string
+}
+
+// NewSigner returns a Signer for the access ID and secret, configured by
+// opts. It returns an error if any option is invalid or the secret is
+// rejected by WithMinSecretLength.
+func NewSigner(accessID, secret string, opts ...Option) (*Signer, error) {
+	o, err := apply(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.checkSecret(secret); err != nil {
+		return nil, err
+	}
+	return &Signer{AccessID: accessID, Secret: secret, AuthorizationHeader: o.authorizationHeader, Canonicalizer: o.Canonicalizer}, nil
+}
+
+// NewVerifier returns a Verifier for the secret, configured by opts. It
+// returns an error if any option is invalid or the secret is rejected by
+// WithMinSecretLength. Servers verifying requests from many clients can
+// pass an empty secret, which is not checked, and set KeyFunc.
+func NewVerifier(secret string, opts ...Option) (*Verifier, error) {
+	o, err := apply(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret != "" {
+		if err := o.checkSecret(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &Verifier{
+		Secret:              secret,
+		Canonicalizer:       o.Canonicalizer,
+		IdempotencyStore:    o.idempotencyStore,
+		AuthorizationHeader: o.authorizationHeader,
+	}, nil
+}
+
+func apply(opts []Option) (*options, error) {
+	o := &options{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// WithMinSecretLength rejects secrets shorter than n bytes, or made of
+// fewer than 8 distinct bytes (or n, if n is less than 8), such as
+// placeholders like `secret` or `xxxxxxxxxxxxxxxx`, with ErrWeakSecret.
+// Secrets from GenerateCredentials are 88 bytes long.
+func WithMinSecretLength(n int) Option {
+	return func(o *options) error {
+		if n < 0 {
+			return fmt.Errorf("apiauth: negative minimum secret length %d", n)
+		}
+		o.minSecretLength = n
+		return nil
+	}
+}
+
+// checkSecret applies WithMinSecretLength to a secret.
+func (o *options) checkSecret(secret string) error {
+	if o.minSecretLength == 0 {
+		return nil
+	}
+
+	if len(secret) < o.minSecretLength {
+		return ErrWeakSecret
+	}
+
+	minDistinct := 8
+	if o.minSecretLength < minDistinct {
+		minDistinct = o.minSecretLength
+	}
+
+	distinct := make(map[byte]bool)
+	for i := 0; i < len(secret); i++ {
+		distinct[secret[i]] = true
+	}
+	if len(distinct) < minDistinct {
+		return ErrWeakSecret
+	}
+	return nil
+}
+
+// WithSignedHeaders adds headers to the SignedHeaders, which must then be
+// present on every request.
+func WithSignedHeaders(names ...string) Option {
+	return func(o *options) error {
+		if err := checkHeaderNames(names); err != nil {
+			return err
+		}
+		o.SignedHeaders = append(o.SignedHeaders, names...)
+		return nil
+	}
+}
+
+// WithOptionalSignedHeaders adds headers to the OptionalSignedHeaders,
+// which are signed when present and as empty when absent.
+func WithOptionalSignedHeaders(names ...string) Option {
+	return func(o *options) error {
+		if err := checkHeaderNames(names); err != nil {
+			return err
+		}
+		o.OptionalSignedHeaders = append(o.OptionalSignedHeaders, names...)
+		return nil
+	}
+}
+
+// WithPreferenceHeaders signs the Prefer (RFC 7240) and Expect headers,
+// which change how a server handles a request, so that they cannot be
+// added, altered or stripped in transit. Both are optional.
+func WithPreferenceHeaders() Option {
+	return WithOptionalSignedHeaders("Prefer", "Expect")
+}
+
+// WithFullURL signs the full URL of each request, including its scheme
+// and host; see Canonicalizer.FullURL. Servers pass the scheme and host
+// they are reached at, which a request may not reliably carry; clients
+// pass empty strings, and sign the scheme and host of the request URL.
+func WithFullURL(scheme, host string) Option {
+	return func(o *options) error {
+		if scheme != "" && scheme != "http" && scheme != "https" {
+			return fmt.Errorf("apiauth: unsupported scheme %q", scheme)
+		}
+		o.FullURL = true
+		o.Scheme = scheme
+		o.Host = host
+		return nil
+	}
+}
+
+// WithIdempotencyStore signs the Idempotency-Key header, which must then
+// be present on every request, and has Verifiers reject requests reusing
+// a key with ErrIdempotencyKeyReused, recording keys in store; see
+// Verifier.IdempotencyStore. Clients pass a nil store.
+func WithIdempotencyStore(store IdempotencyStore) Option {
+	return func(o *options) error {
+		o.SignedHeaders = append(o.SignedHeaders, IdempotencyKeyHeader)
+		o.idempotencyStore = store
+		return nil
+	}
+}
+
+// WithAuthorizationHeader sends the signature in the named header in
+// place of Authorization; see Signer.AuthorizationHeader.
+func WithAuthorizationHeader(name string) Option {
+	return func(o *options) error {
+		if err := checkHeaderNames([]string{name}); err != nil {
+			return err
+		}
+		o.authorizationHeader = name
+		return nil
+	}
+}
+
+// checkHeaderNames checks that each name is a valid header field name.
+func checkHeaderNames(names []string) error {
+	for _, name := range names {
+		if !validHeaderName(name) {
+			return fmt.Errorf("apiauth: invalid header name %q", name)
+		}
+	}
+	return nil
+}
+
+// validHeaderName reports whether name is an RFC 7230 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
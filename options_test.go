@@ -0,0 +1,121 @@
+package apiauth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithPreferenceHeaders(t *testing.T) {
+	s, err := NewSigner("me", "secret", WithPreferenceHeaders())
+	require.NoError(t, err)
+	v, err := NewVerifier("secret", WithPreferenceHeaders())
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Prefer", "return=minimal")
+	require.NoError(t, s.Sign(req))
+	require.Equal(t, ",,/,Fri, 20 Mar 2015 19:37:40 GMT,Prefer:return=minimal,Expect:", v.CanonicalString(req))
+	require.NoError(t, v.Verify(req))
+
+	req.Header.Set("Prefer", "return=representation")
+	require.Equal(t, ErrSignatureMismatch, v.Verify(req))
+
+	req.Header.Del("Prefer")
+	require.Equal(t, ErrSignatureMismatch, v.Verify(req))
+
+	req.Header.Set("Prefer", "return=minimal")
+	req.Header.Set("Expect", "100-continue")
+	require.Equal(t, ErrSignatureMismatch, v.Verify(req))
+
+	// Neither header is required.
+	req, _ = http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.NoError(t, s.Sign(req))
+	require.NoError(t, v.Verify(req))
+}
+
+func TestWithSignedHeaders(t *testing.T) {
+	v, err := NewVerifier("secret", WithSignedHeaders("Accept"), WithSignedHeaders("X-Request-ID"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"Accept", "X-Request-ID"}, v.SignedHeaders)
+
+	for _, name := range []string{"", "Bad Header", "Bad:Header", "Ünicode"} {
+		_, err = NewSigner("me", "secret", WithSignedHeaders("Accept", name))
+		require.Error(t, err, name)
+		_, err = NewVerifier("secret", WithOptionalSignedHeaders(name))
+		require.Error(t, err, name)
+	}
+}
+
+func TestWithMinSecretLength(t *testing.T) {
+	// Without the option, any secret is accepted.
+	_, err := NewSigner("me", "secret")
+	require.NoError(t, err)
+
+	min := WithMinSecretLength(32)
+	for _, secret := range []string{"secret", "short but varied secret!", strings.Repeat("x", 64), strings.Repeat("abc123", 8)} {
+		_, err = NewSigner("me", secret, min)
+		require.Equal(t, ErrWeakSecret, err, secret)
+		_, err = NewVerifier(secret, min)
+		require.Equal(t, ErrWeakSecret, err, secret)
+	}
+
+	_, secret, err := GenerateCredentials()
+	require.NoError(t, err)
+	_, err = NewSigner("me", secret, WithMinSecretLength(64))
+	require.NoError(t, err)
+	_, err = NewVerifier(secret, WithMinSecretLength(64))
+	require.NoError(t, err)
+
+	// An empty Verifier secret is left for KeyFunc.
+	_, err = NewVerifier("", min)
+	require.NoError(t, err)
+	_, err = NewSigner("me", "", min)
+	require.Equal(t, ErrWeakSecret, err)
+
+	// Below 8 bytes, n distinct bytes are enough.
+	_, err = NewSigner("me", "abcd", WithMinSecretLength(4))
+	require.NoError(t, err)
+	_, err = NewVerifier("abcdefg", WithMinSecretLength(4))
+	require.NoError(t, err)
+	_, err = NewSigner("me", "aabb", WithMinSecretLength(4))
+	require.Equal(t, ErrWeakSecret, err)
+
+	_, err = NewSigner("me", secret, WithMinSecretLength(-1))
+	require.Error(t, err)
+}
+
+func TestWithAuthorizationHeader(t *testing.T) {
+	s, err := NewSigner("me", "secret", WithAuthorizationHeader("X-APIAuth-Authorization"))
+	require.NoError(t, err)
+	v, err := NewVerifier("secret", WithAuthorizationHeader("x-apiauth-authorization"))
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "Bearer upstream-token")
+	require.NoError(t, s.Sign(req))
+	require.Equal(t, "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=", req.Header.Get("X-APIAuth-Authorization"))
+	require.Equal(t, "Bearer upstream-token", req.Header.Get("Authorization"))
+	require.NoError(t, v.Verify(req))
+	require.NoError(t, v.VerifyAll(req))
+	require.EqualError(t, s.Sign(req), "X-APIAuth-Authorization header already present")
+
+	// The Authorization header is not read in its place.
+	req.Header.Del("X-APIAuth-Authorization")
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	require.Equal(t, ErrMissingAuthorization, v.Verify(req))
+	require.Equal(t, ErrMissingAuthorization, v.VerifyAll(req))
+
+	req.Header.Del("Authorization")
+	require.NoError(t, s.SignV2(req, HMACSHA256))
+	require.Empty(t, req.Header.Get("Authorization"))
+	require.NoError(t, v.VerifyV2(req))
+
+	_, err = NewSigner("me", "secret", WithAuthorizationHeader("Bad Header"))
+	require.Error(t, err)
+}
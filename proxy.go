@@ -0,0 +1,69 @@
+package apiauth
+
+import (
+	"context"
+	"net/http"
+)
+
+type directorErrorKey struct{}
+
+// ReverseProxyDirector returns a function suitable for use as (or within)
+// an httputil.ReverseProxy's Director. It verifies the inbound request
+// against inboundSecret and replaces its Authorization header with one
+// signed, including the method, by outboundAccessID and outboundSecret.
+//
+// A Director cannot fail, so when verification or signing fails the error
+// is recorded on the request's context for DirectorError, and the request
+// is forwarded with no Authorization header. Wrap the proxy's Transport
+// with DirectorTransport to abort such requests instead, handing the error
+// to the proxy's ErrorHandler.
+//
+// Both signatures are computed over the request as it stands when the
+// function is called, so it should run after Directors that rewrite only
+// the scheme and host, such as that of httputil.NewSingleHostReverseProxy
+// for a target without a path.
+func ReverseProxyDirector(inboundSecret, outboundAccessID, outboundSecret string) func(*http.Request) {
+	return func(r *http.Request) {
+		err := Verify(r, inboundSecret)
+		r.Header.Del("Authorization")
+
+		if err == nil {
+			err = SignWithMethod(r, outboundAccessID, outboundSecret)
+		}
+
+		if err != nil {
+			*r = *r.WithContext(context.WithValue(r.Context(), directorErrorKey{}, err))
+		}
+	}
+}
+
+// DirectorError returns the error recorded on a request by the function
+// returned from ReverseProxyDirector, or nil if it succeeded.
+func DirectorError(r *http.Request) error {
+	err, _ := r.Context().Value(directorErrorKey{}).(error)
+	return err
+}
+
+// DirectorTransport wraps an http.RoundTripper, refusing to send requests
+// for which ReverseProxyDirector recorded an error and returning that error
+// instead. If next is nil, http.DefaultTransport is used.
+func DirectorTransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return directorTransport{next}
+}
+
+type directorTransport struct {
+	next http.RoundTripper
+}
+
+func (t directorTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := DirectorError(r); err != nil {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, err
+	}
+	return t.next.RoundTrip(r)
+}
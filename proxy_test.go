@@ -0,0 +1,81 @@
+package apiauth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseProxyDirector(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _, _ := Parse(r.Header.Get("Authorization"))
+		if id != "proxy" || Verify(r, "backend-secret") != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer backend.Close()
+
+	target, _ := url.Parse(backend.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = DirectorTransport(nil)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		var authErr *AuthError
+		if errors.As(err, &authErr) {
+			w.WriteHeader(authErr.Status)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	director := proxy.Director
+	auth := ReverseProxyDirector("client-secret", "proxy", "backend-secret")
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		auth(r)
+	}
+
+	frontend := httptest.NewServer(proxy)
+	defer frontend.Close()
+
+	req, _ := http.NewRequest("GET", frontend.URL+"/some/path", nil)
+	req.Header.Set("Date", Date())
+	require.NoError(t, Sign(req, "client", "client-secret"))
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	req, _ = http.NewRequest("GET", frontend.URL+"/some/path", nil)
+	req.Header.Set("Date", Date())
+	require.NoError(t, Sign(req, "client", "wrong-secret"))
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestDirectorError(t *testing.T) {
+	director := ReverseProxyDirector("secret", "proxy", "other")
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	director(req)
+	require.Equal(t, ErrMissingDate, DirectorError(req))
+	require.Equal(t, "", req.Header.Get("Authorization"))
+
+	req, _ = http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	director(req)
+	require.NoError(t, DirectorError(req))
+	require.NoError(t, Verify(req, "other"))
+}
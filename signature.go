@@ -0,0 +1,56 @@
+package apiauth
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Signature is the parsed form of an Authorization header value, in
+// either the original `APIAuth access_id:signature` layout or the V2
+// layout; see VerifyV2.
+type Signature struct {
+	// Scheme is `APIAuth`, or `APIAuth-` and the Algorithm for V2.
+	Scheme string
+
+	AccessID string
+
+	// Signature is the encoded MAC.
+	Signature string
+
+	// Algorithm and Timestamp are set only for V2 signatures.
+	Algorithm Algorithm
+	Timestamp time.Time
+}
+
+// ParseSignature parses an Authorization header value in either layout.
+// Values in neither fail with ErrMalformedHeader.
+func ParseSignature(header string) (Signature, error) {
+	if strings.HasPrefix(header, "APIAuth-") {
+		return parseV2(header)
+	}
+
+	id, sig, err := HeaderFormat{}.Parse(header)
+	if err != nil {
+		return Signature{}, err
+	}
+	return Signature{Scheme: "APIAuth", AccessID: id, Signature: sig}, nil
+}
+
+// IsV2 reports whether s is in the V2 layout.
+func (s Signature) IsV2() bool {
+	return s.Algorithm != ""
+}
+
+// String returns s as an Authorization header value.
+func (s Signature) String() string {
+	if s.IsV2() {
+		return "APIAuth-" + string(s.Algorithm) + " " + s.AccessID + ":" + s.timestamp() + ":" + s.Signature
+	}
+	return "APIAuth " + s.AccessID + ":" + s.Signature
+}
+
+// timestamp returns the V2 timestamp as it appears in the header.
+func (s Signature) timestamp() string {
+	return strconv.FormatInt(s.Timestamp.Unix(), 10)
+}
@@ -0,0 +1,90 @@
+package apiauth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSignature(t *testing.T) {
+	for header, want := range map[string]Signature{
+		"APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=": {
+			Scheme:    "APIAuth",
+			AccessID:  "me",
+			Signature: "N7N1BXAWv6+RXos4vSAAd7D0XJY=",
+		},
+		"APIAuth-HMAC-SHA256 me:1426880260:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=": {
+			Scheme:    "APIAuth-HMAC-SHA256",
+			AccessID:  "me",
+			Signature: "IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=",
+			Algorithm: HMACSHA256,
+			Timestamp: time.Unix(1426880260, 0),
+		},
+	} {
+		sig, err := ParseSignature(header)
+		require.NoError(t, err, header)
+		require.Equal(t, want, sig, header)
+		require.Equal(t, header, sig.String())
+		require.Equal(t, want.Algorithm != "", sig.IsV2())
+	}
+
+	for _, header := range []string{
+		"",
+		"APIAuth me",
+		"APIAuth-HMAC-SHA256 me:N7N1BXAWv6+RXos4vSAAd7D0XJY=",
+		"APIAuth-HMAC-SHA256 me:01426880260:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=",
+		"APIAuth-HMAC-SHA256 me:+1426880260:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=",
+	} {
+		_, err := ParseSignature(header)
+		require.Equal(t, ErrMalformedHeader, err, header)
+	}
+
+	// Parse only accepts the original layout.
+	_, _, err := Parse("APIAuth-HMAC-SHA256 me:1426880260:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=")
+	require.Equal(t, ErrMalformedHeader, err)
+}
+
+func TestSigner_Signature(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+
+	s := Signer{AccessID: "me", Secret: "secret"}
+	_, err := s.Signature(req)
+	require.Equal(t, ErrMissingDate, err)
+
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	sig, err := s.Signature(req)
+	require.NoError(t, err)
+	require.Equal(t, Signature{Scheme: "APIAuth", AccessID: "me", Signature: "N7N1BXAWv6+RXos4vSAAd7D0XJY="}, sig)
+	require.Empty(t, req.Header.Get("Authorization"))
+}
+
+func TestVerifier_VerifySignature(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/some/path?x=1", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+
+	v := Verifier{Secret: "secret", Now: func() time.Time { return time.Unix(1426880260, 0) }}
+
+	// The request's own Authorization header is not consulted.
+	req.Header.Set("Authorization", "APIAuth me:bogus")
+	for _, header := range []string{
+		"APIAuth me:" + Compute(CanonicalStringWithMethod(req), "secret"),
+		"APIAuth-HMAC-SHA256 me:1426880260:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=",
+	} {
+		sig, err := ParseSignature(header)
+		require.NoError(t, err)
+		require.NoError(t, v.VerifySignature(req, sig), header)
+
+		sig.AccessID = "you"
+		v.AccessID = "me"
+		require.Equal(t, ErrAccessIDMismatch, v.VerifySignature(req, sig), header)
+		v.AccessID = ""
+
+		sig.Signature = "N7N1BXAWv6+RXos4vSAAd7D0XJY="
+		require.Equal(t, ErrSignatureMismatch, v.VerifySignature(req, sig), header)
+	}
+
+	req.Header.Del("Date")
+	require.Equal(t, ErrMissingDate, v.VerifySignature(req, Signature{Scheme: "APIAuth", AccessID: "me", Signature: "x"}))
+}
@@ -0,0 +1,286 @@
+package apiauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A Signer signs requests with a fixed access ID and secret. The
+// embedded Canonicalizer controls how the canonical string is built,
+// and must match the configuration of the verifying server. A Signer is
+// safe for concurrent use as long as its fields are not modified.
+type Signer struct {
+	AccessID string
+	Secret   string
+
+	// WithMethod includes the request method in the canonical
+	// string, as in SignWithMethod.
+	WithMethod bool
+
+	// DateLayout is the layout used by Date. It defaults to
+	// time.RFC1123.
+	DateLayout string
+
+	// ClockOffset is added to the current time by Date, to correct for
+	// a local clock that disagrees with the server's. See
+	// ServerClockOffset.
+	ClockOffset time.Duration
+
+	// MaxBodySize caps the number of body bytes the body-reading
+	// helpers will buffer. It defaults to DefaultMaxBodySize.
+	MaxBodySize int64
+
+	// JSONContentMD5 makes SetContentMD5 hash the body's JSON normalized
+	// as by ComputeMD5JSON, for servers whose Verifier sets it too.
+	JSONContentMD5 bool
+
+	// DecodedContentMD5 makes SetContentMD5 hash a gzip-encoded body
+	// (one with a Content-Encoding of gzip) decompressed. By default,
+	// as HTTP specifies, the Content-MD5 covers the body as sent, after
+	// any Content-Encoding is applied. Clients and servers must agree:
+	// set it only for servers whose Verifier sets it too.
+	DecodedContentMD5 bool
+
+	// Builder, if set, builds the canonical string in place of the
+	// embedded Canonicalizer, which is still used to check that the
+	// required headers are present.
+	Builder CanonicalBuilder
+
+	// MethodBuilders, if set, maps uppercased request methods to the
+	// builder used for them in place of Builder. For example, mapping
+	// GET to a Canonicalizer whose Fields use FieldPath in place of
+	// FieldURI leaves the query of GET requests unsigned.
+	MethodBuilders map[string]CanonicalBuilder
+
+	// Encoding is the encoding of the signature in the Authorization
+	// header. It defaults to Base64.
+	Encoding SignatureEncoding
+
+	// Format sets the layout of the Authorization header. Only its
+	// Separator is used.
+	Format HeaderFormat
+
+	// AuthorizationHeader names the header the signature is written to,
+	// for infrastructure that reserves Authorization for another
+	// scheme. It defaults to Authorization. The server's Verifier must
+	// read the same header.
+	AuthorizationHeader string
+
+	// ClientCertificate is the DER encoding of the TLS client
+	// certificate requests are sent with, such as the first of the
+	// Certificate chain of a tls.Certificate. It is required when
+	// BindClientCertificate is set.
+	ClientCertificate []byte
+
+	// SigningKey, if set, derives the key signatures are computed with
+	// from Secret and the time in the request's Date header, which must
+	// then parse.
+	SigningKey SigningKeyFunc
+
+	Canonicalizer
+}
+
+// Sign computes the signature for the given HTTP request, and
+// adds the resulting Authorization header value to it. If any
+// of the prerequisite headers are absent, an error is returned.
+func (s *Signer) Sign(r *http.Request) error {
+	r, err := s.withClientCertificate(r)
+	if err != nil {
+		return err
+	}
+	if err := s.sufficientHeaders(r); err != nil {
+		return err
+	}
+
+	name := authorizationHeader(s.AuthorizationHeader)
+	preexisting := r.Header.Get(name)
+	if preexisting != "" {
+		return fmt.Errorf("%s header already present", name)
+	}
+
+	header, err := s.header(r)
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set(name, header)
+	return nil
+}
+
+// Do prepares, signs and sends a request with client, or with
+// http.DefaultClient if client is nil. The Date header, or the one named
+// by DateHeader, is set from Date if absent, unless ExpiresHeader is set
+// and the caller must set the expiry instead, and if the request has a
+// body, the header named by IntegrityHeader is computed from it if
+// absent. If preparing or signing the request fails, the error is
+// returned without sending it, and the body is closed as client.Do would
+// close it.
+func (s *Signer) Do(client *http.Client, r *http.Request) (*http.Response, error) {
+	if err := s.prepare(r); err != nil {
+		if r != nil && r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, err
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(r)
+}
+
+// SignClone signs a deep copy of a request, made with r.Clone, and
+// returns it, leaving r untouched, so that one request can serve as a
+// template signed from several goroutines. The copy's Date and integrity
+// header are set as Do sets them, unless r already carries them. Its body
+// is a fresh copy from r.GetBody, as set by http.NewRequest for in-memory
+// bodies; a body without GetBody cannot be copied, is shared with r, and
+// is read when the integrity header is computed, so such requests must
+// not be shared.
+func (s *Signer) SignClone(r *http.Request) (*http.Request, error) {
+	if r == nil {
+		return nil, ErrNilRequest
+	}
+
+	clone := r.Clone(r.Context())
+	if r.GetBody != nil && r.Body != nil && r.Body != http.NoBody {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+
+	if err := s.prepare(clone); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+// prepare sets the headers Do computes, and signs the request.
+func (s *Signer) prepare(r *http.Request) error {
+	if err := checkRequest(r); err != nil {
+		return err
+	}
+
+	if s.date(r) == "" && s.ExpiresHeader == "" {
+		r.Header.Set(s.dateHeader(), s.Date())
+	}
+
+	if r.Body != nil && r.Body != http.NoBody && r.Header.Get(s.integrityHeader()) == "" {
+		var err error
+		if http.CanonicalHeaderKey(s.integrityHeader()) == DigestHeader {
+			err = s.SetDigest(r)
+		} else {
+			err = s.SetContentMD5(r)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.Sign(r)
+}
+
+// SignHeader computes the signature for the given HTTP request as in
+// Sign, and returns the resulting Authorization header value without
+// adding it to the request. Any Authorization header already present is
+// ignored.
+func (s *Signer) SignHeader(r *http.Request) (string, error) {
+	r, err := s.withClientCertificate(r)
+	if err != nil {
+		return "", err
+	}
+	if err := s.sufficientHeaders(r); err != nil {
+		return "", err
+	}
+	return s.header(r)
+}
+
+// Signature computes the signature for the given HTTP request as in
+// Sign, and returns it without adding it to the request. The Format is
+// not used.
+func (s *Signer) Signature(r *http.Request) (Signature, error) {
+	r, err := s.withClientCertificate(r)
+	if err != nil {
+		return Signature{}, err
+	}
+	if err := s.sufficientHeaders(r); err != nil {
+		return Signature{}, err
+	}
+	return s.signature(r)
+}
+
+func (s *Signer) header(r *http.Request) (string, error) {
+	sig, err := s.signature(r)
+	if err != nil {
+		return "", err
+	}
+	return s.Format.Header(sig.AccessID, sig.Signature), nil
+}
+
+func (s *Signer) signature(r *http.Request) (Signature, error) {
+	var signed time.Time
+	if s.SigningKey != nil {
+		var err error
+		signed, err = ParseDate(s.date(r))
+		if err != nil {
+			return Signature{}, ErrInvalidDate
+		}
+	}
+
+	builder := s.builder(r)
+	canonical := builder.CanonicalString(r)
+	if s.WithMethod {
+		canonical = withMethod(builder, r)
+	}
+
+	mac := keyMAC(signingKey(s.SigningKey, s.Secret, signed))()
+	mac.Write([]byte(canonical))
+	sig := s.Encoding.Encode(mac.Sum(nil))
+	return Signature{Scheme: "APIAuth", AccessID: s.AccessID, Signature: sig}, nil
+}
+
+func (s *Signer) builder(r *http.Request) CanonicalBuilder {
+	if b, ok := s.MethodBuilders[strings.ToUpper(r.Method)]; ok {
+		return b
+	}
+
+	if s.Builder != nil {
+		return s.Builder
+	}
+	return s.Canonicalizer
+}
+
+// withClientCertificate returns the request as the server will see it,
+// with ClientCertificate as its peer certificate. The copy shares the
+// request's headers, so a nil Header is initialized first.
+func (s *Signer) withClientCertificate(r *http.Request) (*http.Request, error) {
+	if err := checkRequest(r); err != nil {
+		return nil, err
+	}
+	if s.ClientCertificate == nil {
+		return r, nil
+	}
+
+	bound := *r
+	bound.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{Raw: s.ClientCertificate}},
+	}
+	return &bound, nil
+}
+
+// Date returns a suitable value for a request's Date header, based
+// on the current time in GMT adjusted by ClockOffset, formatted with
+// the Signer's DateLayout.
+func (s *Signer) Date() string {
+	layout := s.DateLayout
+	if layout == "" {
+		layout = time.RFC1123
+	}
+	return DateForTimeLayout(time.Now().Add(s.ClockOffset), layout)
+}
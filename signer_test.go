@@ -0,0 +1,159 @@
+package apiauth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigner_Date(t *testing.T) {
+	s := Signer{AccessID: "me", Secret: "secret"}
+	_, err := time.Parse(time.RFC1123, s.Date())
+	require.NoError(t, err)
+
+	s.DateLayout = time.RFC1123Z
+	date := s.Date()
+	_, err = time.Parse(time.RFC1123Z, date)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", date)
+	require.NoError(t, s.Sign(req))
+	require.Contains(t, CanonicalString(req), date)
+	require.NoError(t, Verify(req, "secret"))
+}
+
+func TestSigner_ClockOffset(t *testing.T) {
+	s := Signer{ClockOffset: -time.Hour}
+	signed, err := ParseDate(s.Date())
+	require.NoError(t, err)
+	require.InDelta(t, float64(-time.Hour), float64(signed.Sub(time.Now())), float64(2*time.Second))
+}
+
+func TestSignHeader(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com/some/path?x=1&b=2", nil)
+	req.Header.Add("Content-Type", "text/plain")
+	req.Header.Add("Content-MD5", "WnNni3tnQAUFZDSkgFRwfQ==")
+
+	_, err := SignHeader(req, "me", "secret")
+	require.Equal(t, ErrMissingDate, err)
+
+	req.Header.Add("Date", "Thu, 19 Mar 2015 19:24:24 GMT")
+	header, err := SignHeader(req, "me", "secret")
+	require.NoError(t, err)
+	require.Equal(t, "APIAuth me:7TEHD6mE3wiASF/RnhVAL201r4g=", header)
+	require.Empty(t, req.Header.Get("Authorization"))
+
+	// It matches Sign, so verifies against a server accepting only the
+	// canonical string Sign uses.
+	signed := req.Clone(req.Context())
+	require.NoError(t, Sign(signed, "me", "secret"))
+	require.Equal(t, header, signed.Header.Get("Authorization"))
+
+	req.Header.Set("Authorization", header)
+	require.NoError(t, Verify(req, "secret"))
+	legacy := Verifier{Secret: "secret", Builders: []CanonicalBuilder{Canonicalizer{}}}
+	require.NoError(t, legacy.Verify(req))
+	req.Header.Del("Authorization")
+
+	s := Signer{AccessID: "me", Secret: "secret"}
+	header, err = s.SignHeader(req)
+	require.NoError(t, err)
+
+	req.Header.Set("Authorization", header)
+	require.NoError(t, Verify(req, "secret"))
+
+	// An existing header does not prevent computing another.
+	again, err := s.SignHeader(req)
+	require.NoError(t, err)
+	require.Equal(t, header, again)
+}
+
+func TestDo(t *testing.T) {
+	var verifyErr error
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		v := Verifier{Secret: "secret", MaxPast: time.Minute}
+		verifyErr = v.VerifyWithBodyReader(r)
+		w.WriteHeader(StatusCode(verifyErr))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL+"/items", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := Do(server.Client(), req, "me", "secret")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.NoError(t, verifyErr)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "XUFAKrxLKna5cZ2REBfFkg==", req.Header.Get("Content-MD5"))
+
+	req, _ = http.NewRequest("GET", server.URL+"/items", nil)
+	resp, err = Do(nil, req, "me", "secret")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Signing errors are returned without sending the request.
+	req, _ = http.NewRequest("POST", server.URL+"/items", strings.NewReader("hello"))
+	_, err = Do(server.Client(), req, "me", "secret")
+	require.Equal(t, ErrMissingContentType, err)
+
+	req, _ = http.NewRequest("GET", server.URL+"/items", nil)
+	req.Header.Set("Authorization", "APIAuth other:abc")
+	_, err = Do(server.Client(), req, "me", "secret")
+	require.Error(t, err)
+	require.Equal(t, 2, requests)
+
+	_, err = Do(server.Client(), nil, "me", "secret")
+	require.Equal(t, ErrNilRequest, err)
+}
+
+func TestSignClone(t *testing.T) {
+	template, _ := http.NewRequest("POST", "http://example.com/items", strings.NewReader("hello"))
+	template.Header.Set("Content-Type", "text/plain")
+
+	v := Verifier{Secret: "secret", MaxPast: time.Minute}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			signed, err := SignClone(template, "me", "secret")
+			if err == nil {
+				err = v.VerifyWithBodyReader(signed)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	require.Empty(t, template.Header.Get("Authorization"))
+	require.Empty(t, template.Header.Get("Date"))
+	require.Empty(t, template.Header.Get("Content-MD5"))
+	body, err := ioutil.ReadAll(template.Body)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(body))
+
+	_, err = SignClone(nil, "me", "secret")
+	require.Equal(t, ErrNilRequest, err)
+
+	template.Header.Del("Content-Type")
+	_, err = SignClone(template, "me", "secret")
+	require.Equal(t, ErrMissingContentType, err)
+}
@@ -0,0 +1,95 @@
+package apiauth
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"io"
+	"net/http"
+)
+
+// VerifyAndWrapBody verifies the signature of a request as in Verify,
+// without reading its body, and then replaces the body with a reader that
+// checks it against the Content-MD5 header as it is read. When the end of
+// the body is reached, the final Read, and any later Close, return
+// ErrContentMD5Mismatch in place of io.EOF if it does not match. The new
+// body is also returned.
+//
+// Nothing is checked if the body is closed before it is read to the end,
+// so handlers must not act on a body until they have read all of it.
+func VerifyAndWrapBody(r *http.Request, secret string) (io.ReadCloser, error) {
+	v := Verifier{Secret: secret}
+	return v.VerifyAndWrapBody(r)
+}
+
+// VerifyAndWrapBody is as the package-level VerifyAndWrapBody, but checks
+// the body against the header named by IntegrityHeader. A Digest header
+// without a SHA-256 value fails with ErrUnsupportedDigest before any of
+// the body is read.
+func (v *Verifier) VerifyAndWrapBody(r *http.Request) (io.ReadCloser, error) {
+	if err := v.Verify(r); err != nil {
+		return nil, err
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return r.Body, nil
+	}
+
+	body := &checkingBody{body: r.Body}
+	if http.CanonicalHeaderKey(v.integrityHeader()) == DigestHeader {
+		body.hash = sha256.New()
+		body.want = sha256Digest(r.Header.Get(DigestHeader))
+		if body.want == "" {
+			return nil, ErrUnsupportedDigest
+		}
+		body.mismatch = ErrDigestMismatch
+	} else {
+		body.hash = md5.New()
+		body.want = r.Header.Get("Content-MD5")
+		if v.NormalizeContentMD5 {
+			body.want = normalizeMD5(body.want)
+		}
+		body.mismatch = ErrContentMD5Mismatch
+	}
+
+	r.Body = body
+	return body, nil
+}
+
+// checkingBody hashes a body as it is read, and reports a mismatch with
+// the expected checksum at the end of the body.
+type checkingBody struct {
+	body     io.ReadCloser
+	hash     hash.Hash
+	want     string
+	mismatch error
+	err      error
+}
+
+func (b *checkingBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	n, err := b.body.Read(p)
+	b.hash.Write(p[:n])
+
+	if err == io.EOF {
+		if base64.StdEncoding.EncodeToString(b.hash.Sum(nil)) != b.want {
+			b.err = b.mismatch
+			return n, b.err
+		}
+		b.err = io.EOF
+	}
+
+	return n, err
+}
+
+func (b *checkingBody) Close() error {
+	err := b.body.Close()
+	if b.err != nil && b.err != io.EOF {
+		return b.err
+	}
+	return err
+}
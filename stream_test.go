@@ -0,0 +1,76 @@
+package apiauth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyAndWrapBody(t *testing.T) {
+	body := []byte(`post body`)
+	newRequest := func(contentMD5 string) *http.Request {
+		req, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader(body))
+		req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Content-MD5", contentMD5)
+		require.NoError(t, Sign(req, "me", "secret"))
+		return req
+	}
+
+	req := newRequest(base64md5(body))
+	wrapped, err := VerifyAndWrapBody(req, "secret")
+	require.NoError(t, err)
+	require.Equal(t, wrapped, req.Body)
+
+	read, err := ioutil.ReadAll(wrapped)
+	require.NoError(t, err)
+	require.Equal(t, body, read)
+	require.NoError(t, wrapped.Close())
+
+	req = newRequest("WnNni3tnQAUFZDSkgFRwfQ==")
+	wrapped, err = VerifyAndWrapBody(req, "secret")
+	require.NoError(t, err)
+
+	read, err = ioutil.ReadAll(wrapped)
+	require.Equal(t, ErrContentMD5Mismatch, err)
+	require.Equal(t, body, read)
+	require.Equal(t, ErrContentMD5Mismatch, wrapped.Close())
+
+	req = newRequest(base64md5(body))
+	_, err = VerifyAndWrapBody(req, "wrong")
+	require.Equal(t, ErrSignatureMismatch, err)
+}
+
+func TestVerifier_VerifyAndWrapBody_Digest(t *testing.T) {
+	body := []byte(`post body`)
+	c := Canonicalizer{IntegrityHeader: DigestHeader}
+
+	req, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader(body))
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Content-Type", "text/plain")
+	require.NoError(t, SetDigest(req))
+	require.NoError(t, (&Signer{AccessID: "me", Secret: "secret", Canonicalizer: c}).Sign(req))
+
+	v := Verifier{Secret: "secret", Canonicalizer: c}
+	wrapped, err := v.VerifyAndWrapBody(req)
+	require.NoError(t, err)
+
+	read, err := ioutil.ReadAll(wrapped)
+	require.NoError(t, err)
+	require.Equal(t, body, read)
+
+	// Without a SHA-256 digest, nothing is read.
+	unread := bytes.NewReader(body)
+	req, _ = http.NewRequest("POST", "http://example.com", unread)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set(DigestHeader, "MD5="+base64md5(body))
+	require.NoError(t, (&Signer{AccessID: "me", Secret: "secret", Canonicalizer: c}).Sign(req))
+
+	_, err = v.VerifyAndWrapBody(req)
+	require.Equal(t, ErrUnsupportedDigest, err)
+	require.Equal(t, len(body), unread.Len())
+}
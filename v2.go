@@ -0,0 +1,348 @@
+package apiauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxPast is how far in the past the timestamp of a V2 request may
+// be when a Verifier does not set MaxPast. It matches the 15 minutes the
+// Ruby gem allows.
+const DefaultMaxPast = 15 * time.Minute
+
+// An Algorithm is a signature algorithm named in a V2 Authorization
+// header.
+type Algorithm string
+
+// The supported signature algorithms.
+const (
+	HMACSHA1   Algorithm = "HMAC-SHA1"
+	HMACSHA256 Algorithm = "HMAC-SHA256"
+
+	// BLAKE2b512 is keyed BLAKE2b with a 64-byte digest. It is only
+	// supported once registered by importing
+	// github.com/pd/apiauth/blake2b, so that the dependency on
+	// golang.org/x/crypto is only taken on by programs that use it.
+	BLAKE2b512 Algorithm = "BLAKE2b-512"
+)
+
+var (
+	algorithmsMu sync.RWMutex
+	algorithms   = map[Algorithm]func(key []byte) hash.Hash{}
+)
+
+// RegisterAlgorithm makes a keyed MAC algorithm available for the V2
+// scheme under the given name, which then appears in Authorization
+// headers as `APIAuth-<name>`. newMAC returns a fresh MAC keyed by key,
+// for keys of any length. It is meant to be called from the init
+// function of a package implementing the algorithm, and panics if the
+// name is already registered or newMAC is nil.
+func RegisterAlgorithm(name Algorithm, newMAC func(key []byte) hash.Hash) {
+	algorithmsMu.Lock()
+	defer algorithmsMu.Unlock()
+
+	if newMAC == nil {
+		panic("apiauth: RegisterAlgorithm newMAC is nil")
+	}
+	if _, dup := algorithms[name]; dup || name == HMACSHA1 || name == HMACSHA256 {
+		panic("apiauth: RegisterAlgorithm called twice for " + string(name))
+	}
+	algorithms[name] = newMAC
+}
+
+// newMAC returns a function creating MACs of the algorithm keyed by
+// key, or false if the algorithm is not supported.
+func (a Algorithm) newMAC(key []byte) (func() hash.Hash, bool) {
+	var h func() hash.Hash
+	switch a {
+	case HMACSHA1:
+		h = sha1.New
+	case HMACSHA256:
+		h = sha256.New
+	default:
+		algorithmsMu.RLock()
+		newMAC, ok := algorithms[a]
+		algorithmsMu.RUnlock()
+		if !ok {
+			return nil, false
+		}
+
+		return func() hash.Hash {
+			return newMAC(key)
+		}, true
+	}
+
+	return func() hash.Hash {
+		return hmac.New(h, key)
+	}, true
+}
+
+// SignV2 signs a request with the V2 scheme using HMAC-SHA256 and the
+// current time, adding the resulting Authorization header to it. The Date
+// header is not required. If any of the other prerequisite headers are
+// absent, an error is returned.
+func SignV2(r *http.Request, accessID, secret string) error {
+	s := Signer{AccessID: accessID, Secret: secret}
+	return s.SignV2(r, HMACSHA256)
+}
+
+// VerifyV2 checks a request signed with the V2 scheme: all required
+// headers are present, the timestamp is within DefaultMaxPast in the past
+// and DefaultMaxFuture in the future, and the signature matches the one
+// computed with the named algorithm and the secret keyFunc returns for the
+// access ID. Errors from keyFunc are returned as they are.
+//
+// The V2 scheme is the recommended format for new integrations. Its
+// Authorization header names the signature algorithm and carries the
+// signing time, so that neither relies on out-of-band agreement:
+//
+//	header    = "APIAuth-" algorithm SP access-id ":" timestamp ":" signature
+//	algorithm = "HMAC-SHA1" / "HMAC-SHA256" / registered-algorithm
+//	access-id = 1*( any character except ":" )
+//	timestamp = 1*DIGIT     ; seconds since the Unix epoch
+//	signature = 1*( any character )
+//
+// For example, `APIAuth-HMAC-SHA256 me:1426880260:<signature>`. The
+// signature is the MAC, in base64 unless another SignatureEncoding is
+// configured, of the canonical string with the method, followed by a comma
+// and the timestamp exactly as it appears in the header:
+//
+//	METHOD,Content-Type,Content-MD5,URI,Date,timestamp
+//
+// The Date header is optional, since the timestamp makes it redundant, and
+// clients such as browsers may be unable to set it. Without one, the
+// timestamp takes its place in the canonical string:
+//
+//	METHOD,Content-Type,Content-MD5,URI,timestamp
+//
+// The other headers required by Sign are still required. Algorithms other
+// than the HMACs, such as BLAKE2b512, are only accepted once registered
+// with RegisterAlgorithm.
+func VerifyV2(r *http.Request, keyFunc KeyFunc) error {
+	v := Verifier{KeyFunc: keyFunc}
+	return v.VerifyV2(r)
+}
+
+// SignV2 signs a request with the V2 scheme and the given algorithm, at
+// the current time adjusted by ClockOffset. WithMethod and DateLayout
+// are ignored; the method is always signed. SigningKey is given the
+// time in the timestamp.
+func (s *Signer) SignV2(r *http.Request, alg Algorithm) error {
+	r, err := s.withClientCertificate(r)
+	if err != nil {
+		return err
+	}
+	if err := s.sufficientHeadersExceptDate(r); err != nil {
+		return err
+	}
+
+	name := authorizationHeader(s.AuthorizationHeader)
+	if r.Header.Get(name) != "" {
+		return fmt.Errorf("%s header already present", name)
+	}
+
+	signed := time.Unix(time.Now().Add(s.ClockOffset).Unix(), 0)
+	newMAC, ok := alg.newMAC(signingKey(s.SigningKey, s.Secret, signed))
+	if !ok {
+		return ErrUnsupportedAlgorithm
+	}
+
+	sig := Signature{Scheme: "APIAuth-" + string(alg), AccessID: s.AccessID, Algorithm: alg, Timestamp: signed}
+	mac := newMAC()
+	mac.Write([]byte(canonicalStringV2(s.builder(r), r, s.dateHeader(), sig.timestamp())))
+	sig.Signature = s.Encoding.Encode(mac.Sum(nil))
+
+	r.Header.Set(name, sig.String())
+	return nil
+}
+
+// VerifyV2 checks a request signed with the V2 scheme, as the
+// package-level VerifyV2 does, honoring the Verifier's options. The
+// timestamp is checked against MaxPast, defaulting to DefaultMaxPast, and
+// MaxFuture. SigningKey is given the time in the timestamp. Builders and
+// Format are not used, nor is KeyProvider: a Verifier with no other key
+// source fails with an error that is not an AuthError.
+func (v *Verifier) VerifyV2(r *http.Request) error {
+	start := time.Now()
+	sig, err := v.verifyV2(r)
+	v.report(start, sig.AccessID, 0, err)
+	return v.annotate(r, err)
+}
+
+// verifyV2 checks the request, returning the signature it carries.
+func (v *Verifier) verifyV2(r *http.Request) (Signature, error) {
+	if err := v.checkHeadersV2(r); err != nil {
+		return Signature{}, err
+	}
+
+	auth := v.authorization(r)
+	if auth == "" {
+		return Signature{}, ErrMissingAuthorization
+	}
+
+	sig, err := parseV2(auth)
+	if err != nil {
+		return Signature{}, err
+	}
+
+	if err := v.verifySignatureV2(r, sig); err != nil {
+		return sig, err
+	}
+
+	return sig, v.checkIdempotencyKey(r, sig.AccessID)
+}
+
+// checkHeadersV2 makes the checks of the request that precede verifying
+// its V2 signature.
+func (v *Verifier) checkHeadersV2(r *http.Request) error {
+	if err := checkRequest(r); err != nil {
+		return err
+	}
+
+	if err := v.checkTLS(r); err != nil {
+		return err
+	}
+
+	if !v.methodAllowed(r.Method) {
+		return ErrMethodNotAllowed
+	}
+
+	if v.RejectEmptyBody && v.requiresBody(r.Method) && (r.Body == nil || r.Body == http.NoBody) {
+		return ErrEmptyBodyNotAllowed
+	}
+
+	if err := v.sufficientHeadersExceptDate(r); err != nil {
+		return err
+	}
+
+	if err := v.requiredContentHeaders(r); err != nil {
+		return err
+	}
+
+	if err := v.checkIdempotencyKeySigned(r); err != nil {
+		return err
+	}
+
+	if !v.contentTypeAllowed(r) {
+		return ErrContentTypeNotAllowed
+	}
+
+	if v.ExpiresHeader != "" && r.Header.Get(v.ExpiresHeader) != "" {
+		return v.checkExpires(r)
+	}
+
+	return nil
+}
+
+// verifySignatureV2 checks a V2 signature against the request.
+func (v *Verifier) verifySignatureV2(r *http.Request, sig Signature) error {
+	maxPast := v.MaxPast
+	if maxPast <= 0 {
+		maxPast = DefaultMaxPast
+	}
+
+	if err := v.checkTime(sig.Timestamp, maxPast); err != nil {
+		return err
+	}
+
+	if err := v.checkAccessID(sig.AccessID); err != nil {
+		return err
+	}
+
+	secrets, err := v.keys(sig.AccessID, sig.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	canonicalString := canonicalStringV2(v.builder(r), v.signedRequest(r), v.dateHeader(), sig.timestamp())
+	verified := false
+	for i, secret := range secrets {
+		newMAC, ok := sig.Algorithm.newMAC(signingKey(v.SigningKey, secret, sig.Timestamp))
+		if !ok {
+			return ErrUnsupportedAlgorithm
+		}
+
+		if i == 0 && v.RejectSuspiciousSignatures && !v.plausibleMAC(sig.Signature, newMAC) {
+			return ErrSuspiciousSignature
+		}
+
+		if verifyMAC(sig.Signature, canonicalString, newMAC, v.Encoding) {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return ErrSignatureMismatch
+	}
+
+	if v.AccessID != "" && subtle.ConstantTimeCompare([]byte(sig.AccessID), []byte(v.AccessID)) != 1 {
+		return ErrAccessIDMismatch
+	}
+
+	return nil
+}
+
+// canonicalStringV2 returns the string signed in the V2 scheme, where
+// dateHeader names the header carrying the Date.
+func canonicalStringV2(b CanonicalBuilder, r *http.Request, dateHeader, ts string) string {
+	if r.Header.Get(dateHeader) != "" {
+		return withMethod(b, r) + "," + ts
+	}
+
+	// Sign the timestamp in place of the absent Date.
+	dated := *r
+	dated.Header = r.Header.Clone()
+	dated.Header.Set(dateHeader, ts)
+	return withMethod(b, &dated)
+}
+
+// parseV2 parses a V2 Authorization header. The timestamp must be
+// written as strconv.FormatInt would write it, so that it can be
+// reproduced exactly from the parsed time.
+func parseV2(header string) (Signature, error) {
+	var tokens []string
+	var alg string
+	var unix int64
+	var err error
+
+	if !strings.HasPrefix(header, "APIAuth-") {
+		goto malformed
+	}
+
+	tokens = strings.SplitN(header[8:], " ", 2)
+	if len(tokens) != 2 || tokens[0] == "" {
+		goto malformed
+	}
+	alg = tokens[0]
+
+	tokens = strings.SplitN(tokens[1], ":", 3)
+	if len(tokens) != 3 || tokens[0] == "" || tokens[1] == "" || tokens[2] == "" {
+		goto malformed
+	}
+
+	unix, err = strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil || strconv.FormatInt(unix, 10) != tokens[1] {
+		goto malformed
+	}
+
+	return Signature{
+		Scheme:    "APIAuth-" + alg,
+		AccessID:  tokens[0],
+		Signature: tokens[2],
+		Algorithm: Algorithm(alg),
+		Timestamp: time.Unix(unix, 0),
+	}, nil
+
+malformed:
+	return Signature{}, ErrMalformedHeader
+}
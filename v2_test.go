@@ -0,0 +1,167 @@
+package apiauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha512"
+	"hash"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func v2Request(auth string) *http.Request {
+	req, _ := http.NewRequest("GET", "http://example.com/some/path?x=1", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", auth)
+	return req
+}
+
+func v2Keys(accessID string) (string, error) {
+	if accessID != "me" {
+		return "", errUnknownKey
+	}
+	return "secret", nil
+}
+
+func TestVerifier_VerifyV2(t *testing.T) {
+	signed := time.Unix(1426880260, 0)
+	v := Verifier{KeyFunc: v2Keys, Now: func() time.Time { return signed.Add(time.Minute) }}
+
+	// Signatures computed independently with Python's hmac module.
+	for _, auth := range []string{
+		"APIAuth-HMAC-SHA256 me:1426880260:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=",
+		"APIAuth-HMAC-SHA1 me:1426880260:VCgnUk1OmPWd13sbfhssgCVvsUA=",
+	} {
+		require.NoError(t, v.VerifyV2(v2Request(auth)), auth)
+	}
+
+	for auth, want := range map[string]error{
+		"APIAuth-HMAC-SHA256 me:1426880261:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=":  ErrSignatureMismatch,
+		"APIAuth-HMAC-SHA1 me:1426880260:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=":    ErrSignatureMismatch,
+		"APIAuth-HMAC-MD5 me:1426880260:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=":     ErrUnsupportedAlgorithm,
+		"APIAuth-BLAKE2b-512 me:1426880260:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=":  ErrUnsupportedAlgorithm,
+		"APIAuth-HMAC-SHA256 you:1426880260:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=": errUnknownKey,
+		"APIAuth-HMAC-SHA256 me:1426879000:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=":  ErrDateTooOld,
+		"APIAuth-HMAC-SHA256 me:1426890000:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=":  ErrDateInFuture,
+		"APIAuth-HMAC-SHA256 me:yesterday:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=":   ErrMalformedHeader,
+		"APIAuth-HMAC-SHA256 me:IBOCAuppz9amrRFLOF7+zMiwYvUSinvR3uu9GBiCVtU=":             ErrMalformedHeader,
+		"APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=":                                         ErrMalformedHeader,
+		"APIAuth-HMAC-SHA256": ErrMalformedHeader,
+	} {
+		require.Equal(t, want, v.VerifyV2(v2Request(auth)), auth)
+	}
+}
+
+func TestSignV2(t *testing.T) {
+	req, _ := http.NewRequest("PUT", "http://example.com/some/path?x=1", strings.NewReader("body"))
+	req.Header.Set("Date", Date())
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-MD5", base64md5([]byte("body")))
+
+	require.NoError(t, SignV2(req, "me", "secret"))
+	require.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "APIAuth-HMAC-SHA256 me:"))
+	require.NoError(t, VerifyV2(req, v2Keys))
+
+	// The method is always signed.
+	req.Method = "POST"
+	require.Equal(t, ErrSignatureMismatch, VerifyV2(req, v2Keys))
+
+	// Legacy verification does not accept V2 headers.
+	require.Equal(t, ErrMalformedHeader, Verify(req, "secret"))
+}
+
+func TestSigner_SignV2(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Date", Date())
+
+	s := Signer{AccessID: "me", Secret: "secret", Encoding: Hex}
+	require.Equal(t, ErrUnsupportedAlgorithm, s.SignV2(req, "HMAC-MD5"))
+	require.NoError(t, s.SignV2(req, HMACSHA1))
+
+	v := Verifier{Secret: "secret", Encoding: Hex}
+	require.NoError(t, v.VerifyV2(req))
+
+	v = Verifier{Secret: "secret", AccessID: "you", Encoding: Hex}
+	require.Equal(t, ErrAccessIDMismatch, v.VerifyV2(req))
+}
+
+func TestVerifyV2_NoDate(t *testing.T) {
+	signed := time.Unix(1426880260, 0)
+	v := Verifier{KeyFunc: v2Keys, Now: func() time.Time { return signed }}
+
+	// Signature computed independently with Python's hmac module, over
+	// the canonical string with the timestamp in place of the Date.
+	req := v2Request("APIAuth-HMAC-SHA256 me:1426880260:h0nWAS42F7VjEAbUiKUitB2c6HjJpVExEARnH02wuuI=")
+	req.Header.Del("Date")
+	require.NoError(t, v.VerifyV2(req))
+	require.Empty(t, req.Header.Get("Date"))
+
+	// Adding a Date changes the canonical string.
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.Equal(t, ErrSignatureMismatch, v.VerifyV2(req))
+
+	// The timestamp is still checked.
+	req = v2Request("APIAuth-HMAC-SHA256 me:1426880260:h0nWAS42F7VjEAbUiKUitB2c6HjJpVExEARnH02wuuI=")
+	req.Header.Del("Date")
+	v.Now = func() time.Time { return signed.Add(time.Hour) }
+	require.Equal(t, ErrDateTooOld, v.VerifyV2(req))
+
+	// Legacy verification still requires the Date.
+	require.Equal(t, ErrMissingDate, Verify(req, "secret"))
+}
+
+func TestSignV2_NoDate(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/some/path", nil)
+	require.NoError(t, SignV2(req, "me", "secret"))
+	require.Empty(t, req.Header.Get("Date"))
+	require.NoError(t, VerifyV2(req, v2Keys))
+}
+
+func TestRegisterAlgorithm(t *testing.T) {
+	require.Panics(t, func() { RegisterAlgorithm(HMACSHA256, func(key []byte) hash.Hash { return nil }) })
+	require.Panics(t, func() { RegisterAlgorithm("HMAC-SHA512", nil) })
+
+	RegisterAlgorithm("HMAC-SHA512", func(key []byte) hash.Hash { return hmac.New(sha512.New, key) })
+	defer func() {
+		algorithmsMu.Lock()
+		delete(algorithms, "HMAC-SHA512")
+		algorithmsMu.Unlock()
+	}()
+	require.Panics(t, func() { RegisterAlgorithm("HMAC-SHA512", func(key []byte) hash.Hash { return nil }) })
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	s := Signer{AccessID: "me", Secret: "secret"}
+	require.NoError(t, s.SignV2(req, "HMAC-SHA512"))
+	require.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "APIAuth-HMAC-SHA512 me:"))
+
+	v := Verifier{Secret: "secret"}
+	require.NoError(t, v.VerifyV2(req))
+}
+
+func TestVerifyV2_KeyProviderOnly(t *testing.T) {
+	v := Verifier{KeyProvider: MACFunc(func() hash.Hash { return hmac.New(sha1.New, []byte("secret")) })}
+
+	req, _ := http.NewRequest("GET", "http://example.com/items", nil)
+	require.NoError(t, (&Signer{AccessID: "me"}).SignV2(req, HMACSHA256))
+
+	sig, err := ParseSignature(req.Header.Get("Authorization"))
+	require.NoError(t, err)
+	require.Equal(t, errKeyProviderUnsupported, v.VerifySignature(req, sig))
+	require.Equal(t, errKeyProviderUnsupported, v.VerifyV2(req))
+	v.RequireV2 = true
+	require.Equal(t, errKeyProviderUnsupported, v.Verify(req))
+
+	// Requests signed with an empty secret never verify.
+	empty := Verifier{KeyFunc: func(string) (string, error) { return "", nil }}
+	require.Equal(t, ErrSignatureMismatch, empty.VerifyV2(req))
+	require.Equal(t, ErrSignatureMismatch, (&Verifier{}).VerifyV2(req))
+
+	legacy, _ := http.NewRequest("GET", "http://example.com/items", nil)
+	legacy.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.NoError(t, Sign(legacy, "me", ""))
+	require.Equal(t, ErrSignatureMismatch, empty.Verify(legacy))
+}
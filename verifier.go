@@ -0,0 +1,962 @@
+package apiauth
+
+import (
+	"crypto/subtle"
+	"hash"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultBodyMethods are the methods a Verifier with RejectEmptyBody
+// requires a body for when BodyMethods is not set.
+var DefaultBodyMethods = []string{"POST", "PUT", "PATCH"}
+
+// DefaultAccessIDPattern is the pattern a Verifier with ValidateAccessID
+// checks access IDs against when AccessIDPattern is not set: one or more
+// ASCII letters, digits, hyphens and underscores, as GenerateCredentials
+// creates.
+var DefaultAccessIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// DefaultMutatingMethods are the methods a Verifier with
+// RequireMethodForMutating requires signatures including the method for
+// when MutatingMethods is not set.
+var DefaultMutatingMethods = []string{"POST", "PUT", "PATCH", "DELETE"}
+
+// DefaultMaxFuture is how far in the future a request's Date may be
+// when a Verifier sets MaxPast but not MaxFuture.
+const DefaultMaxFuture = 5 * time.Minute
+
+// A Verifier verifies signed requests against a secret. The embedded
+// Canonicalizer controls how the canonical string is built, and must
+// match the configuration of the signing client. A Verifier is safe for
+// concurrent use as long as its fields are not modified, and any
+// functions it is given are safe for concurrent use themselves.
+type Verifier struct {
+	Secret string
+
+	// AccessID, if set, is the only access ID accepted: requests signed
+	// under any other fail with ErrAccessIDMismatch, even if their
+	// signature is valid for the secret.
+	AccessID string
+
+	// ValidateAccessID rejects requests whose access ID does not match
+	// AccessIDPattern with ErrInvalidAccessID, before any secret is
+	// looked up, so that an ID carrying control characters or
+	// separators never reaches a KeyFunc, a cache key or a log line.
+	ValidateAccessID bool
+
+	// AccessIDPattern is the pattern ValidateAccessID checks access IDs
+	// against. It must match the whole ID, so should be anchored. It
+	// defaults to DefaultAccessIDPattern.
+	AccessIDPattern *regexp.Regexp
+
+	// KeyProvider, if set, supplies the keyed MACs used to compute
+	// signatures in place of Secret. It only verifies the original
+	// layout: V2 and message signatures then need another key source.
+	KeyProvider KeyProvider
+
+	// DatedKeyFunc, if set, returns the secret for each request from its
+	// access ID and the time in its Date header, in place of Secret and
+	// KeyProvider, for keys that rotate on a schedule. Requests whose Date
+	// cannot be parsed fail with ErrInvalidDate, and errors it returns are
+	// returned from Verify as they are.
+	DatedKeyFunc func(accessID string, signedTime time.Time) (string, error)
+
+	// VersionedKeyFunc, if set, returns every version of the secret for
+	// each request's access ID, in place of Secret, KeyProvider,
+	// DatedKeyFunc and KeyFunc, for keys that rotate with overlapping
+	// validity windows. A signature verifies if it matches any version
+	// whose window contains the time in the request's Date header.
+	// Requests whose Date cannot be parsed fail with ErrInvalidDate, and
+	// errors it returns are returned from Verify as they are.
+	VersionedKeyFunc func(accessID string) ([]VersionedSecret, error)
+
+	// KeyFunc, if set, returns the secret for each request from its
+	// access ID, in place of Secret and KeyProvider. Errors it returns
+	// are returned from Verify as they are.
+	KeyFunc KeyFunc
+
+	// SigningKey, if set, derives the key signatures are computed with
+	// from the secret and the time in the request's Date header, as the
+	// client's Signer does. Requests whose Date cannot be parsed fail
+	// with ErrInvalidDate. It is not applied to KeyProvider's MACs.
+	SigningKey SigningKeyFunc
+
+	// MaxBodySize caps the number of body bytes the body-reading
+	// helpers will buffer. It defaults to DefaultMaxBodySize.
+	MaxBodySize int64
+
+	// JSONContentMD5 checks the Content-MD5 of bodies read by the
+	// body-reading helpers against their JSON normalized as by
+	// ComputeMD5JSON, for clients whose Signer sets it too. It is not
+	// supported by VerifyAndWrapBody, which hashes the body as it is.
+	JSONContentMD5 bool
+
+	// DecodedContentMD5 checks the Content-MD5 of bodies read by the
+	// body-reading helpers against the body with its Content-Encoding
+	// undone, for clients whose Signer sets it too; see the Signer
+	// field. It is not supported by VerifyAndWrapBody.
+	DecodedContentMD5 bool
+
+	// RequireContentMD5 requires the Content-Type and Content-MD5 (or
+	// IntegrityHeader) headers on every request, failing those without
+	// them with ErrMissingContentType or the missing header error, in
+	// place of only on requests with a body. Clients must then send the
+	// MD5 of the empty body on GET requests.
+	RequireContentMD5 bool
+
+	// MinTLSVersion, if set, rejects requests made over a TLS
+	// connection older than it, such as tls.VersionTLS12, or not over
+	// TLS at all, with ErrWeakTLS, so that a valid signature is not
+	// enough over a downgraded connection. It is read from r.TLS, so
+	// servers behind a proxy that terminates TLS cannot use it.
+	MinTLSVersion uint16
+
+	// AllowedMethods, if set, lists the only request methods that will
+	// be verified; any other method is rejected with ErrMethodNotAllowed
+	// before the signature is checked.
+	AllowedMethods []string
+
+	// RejectEmptyBody rejects requests without a body whose method is
+	// one of BodyMethods with ErrEmptyBodyNotAllowed, for endpoints where
+	// a missing body is always a client error, rather than verifying
+	// them without one. A body is only missing when r.Body is nil or
+	// http.NoBody, as it is on servers for requests with a
+	// Content-Length of 0.
+	RejectEmptyBody bool
+
+	// BodyMethods lists the methods RejectEmptyBody requires a body
+	// for. It defaults to DefaultBodyMethods.
+	BodyMethods []string
+
+	// AllowedContentTypes, if set, lists the only media types accepted
+	// for requests with a body; any other Content-Type, ignoring its
+	// parameters and case, is rejected with ErrContentTypeNotAllowed.
+	AllowedContentTypes []string
+
+	// MaxPast, if set, rejects requests whose Date is more than MaxPast
+	// before the current time with ErrDateTooOld.
+	MaxPast time.Duration
+
+	// MaxFuture rejects requests whose Date is more than MaxFuture after
+	// the current time with ErrDateInFuture. It defaults to
+	// DefaultMaxFuture when MaxPast is set; the Date is not checked at
+	// all when neither is set.
+	MaxFuture time.Duration
+
+	// RejectBefore, if set, rejects requests whose Date is before it with
+	// ErrDateTooOld. Setting it to the time the process started, e.g.
+	// time.Now() when the Verifier is created, bounds the replay window
+	// to the life of the process when any record of seen requests is
+	// held in memory. Dates have one-second precision, so a request
+	// signed in the same second as RejectBefore may be rejected.
+	RejectBefore time.Time
+
+	// SameUTCDay rejects requests whose Date, or V2 timestamp, is not on
+	// the current UTC calendar day with ErrDateNotToday, for endpoints
+	// that only accept requests signed on the day they are processed. A
+	// request signed just before midnight is rejected if it arrives just
+	// after. It applies in addition to MaxPast and MaxFuture.
+	SameUTCDay bool
+
+	// Now returns the current time for date checks. It defaults to
+	// time.Now.
+	Now func() time.Time
+
+	// Builder, if set, builds the canonical string in place of the
+	// embedded Canonicalizer, which is still used to check that the
+	// required headers are present.
+	Builder CanonicalBuilder
+
+	// MethodBuilders, if set, maps uppercased request methods to the
+	// builder used for them in place of Builder. For example, mapping
+	// GET to a Canonicalizer whose Fields use FieldPath in place of
+	// FieldURI leaves the query of GET requests unsigned.
+	MethodBuilders map[string]CanonicalBuilder
+
+	// Builders, if set, lists the canonical string formats a signature
+	// may match, in order of preference, in place of the default of
+	// WithMethod(b) followed by b itself, where b is the builder from
+	// MethodBuilders, Builder or the embedded Canonicalizer. It allows
+	// migrating between formats by accepting old ones for a grace
+	// period.
+	Builders []CanonicalBuilder
+
+	// RequireMethodForMutating accepts only signatures including the
+	// request method for requests whose method is one of
+	// MutatingMethods, rejecting the deprecated canonical string without
+	// it with ErrSignatureMismatch, while still accepting it for other
+	// methods. A signature without the method can be replayed under
+	// another method, which only matters for requests that change
+	// state. It has no effect when Builders is set.
+	RequireMethodForMutating bool
+
+	// MutatingMethods lists the methods RequireMethodForMutating applies
+	// to. It defaults to DefaultMutatingMethods.
+	MutatingMethods []string
+
+	// Format sets the accepted layouts of the Authorization header.
+	Format HeaderFormat
+
+	// RequireV2 makes Verify, VerifyFormat and VerifyWithBodyReader
+	// check requests as VerifyV2 does, rejecting the original scheme.
+	RequireV2 bool
+
+	// PathPrefix, if set, is prepended to the request path before the
+	// canonical string is built, for servers behind a proxy that strips
+	// it: with PathPrefix "/api", a request for /users is verified as
+	// the /api/users the client signed.
+	PathPrefix string
+
+	// Encoding is the encoding of the signature in the Authorization
+	// header. It defaults to Base64.
+	Encoding SignatureEncoding
+
+	// RejectSuspiciousSignatures rejects signatures that do not decode
+	// to a MAC of the expected length with ErrSuspiciousSignature, in
+	// place of ErrSignatureMismatch. Such a signature was never computed
+	// by a correctly configured client, e.g. because the access ID was
+	// sent in its place, so this tells configuration mistakes apart from
+	// wrong secrets.
+	RejectSuspiciousSignatures bool
+
+	// AuthorizationHeader names the header the signature is read from,
+	// for infrastructure that reserves Authorization for another
+	// scheme. It defaults to Authorization. The client's Signer must
+	// write the same header.
+	AuthorizationHeader string
+
+	// AuthorizationCookie, if set, names a cookie from which the
+	// Authorization value is read when the header is absent, for
+	// browser clients that cannot set the header.
+	AuthorizationCookie string
+
+	// URLDecodeAuthorization percent-decodes the Authorization value,
+	// from the header or AuthorizationCookie, before it is parsed, for
+	// servers behind proxies that URL-encode it, sending the `+` and `/`
+	// of a base64 signature as `%2B` and `%2F`. A `+` is left as it is
+	// rather than decoded as a space. Values that are not valid
+	// percent-encoding are parsed as they are. It is opt-in because
+	// decoding is not idempotent: a value decoded twice, by the proxy
+	// and here, is altered if it contains a `%`. Base64 signatures never
+	// do, but access IDs might, and decode to another ID.
+	URLDecodeAuthorization bool
+
+	// RequestIDHeader, if set, names a header (typically X-Request-ID)
+	// carrying a client-chosen ID for each request. Verification
+	// failures for requests that carry one are returned as a
+	// *RequestError holding the ID. List the header in SignedHeaders as
+	// well to bind it to the signature.
+	RequestIDHeader string
+
+	// OnSuccess, if set, is called by Verify with the access ID of each
+	// request that verifies.
+	OnSuccess func(accessID string)
+
+	// OnFailure, if set, is called by Verify for each request that does
+	// not verify, with the Code of the resulting AuthError as the reason.
+	OnFailure func(reason string)
+
+	// OnLegacyScheme, if set, is called by Verify with the access ID of
+	// each request that verifies using any format but the first of
+	// Builders; by default, the deprecated canonical string without the
+	// request method.
+	OnLegacyScheme func(accessID string)
+
+	// OnVerifyTiming, if set, is called by Verify after each request is
+	// checked, with how long checking it took and whether it verified,
+	// for feeding detectors of timing-based probing. It is called
+	// whenever OnSuccess or OnFailure would be, and for VerifyAll once
+	// per signature.
+	OnVerifyTiming func(d time.Duration, ok bool)
+
+	// IdempotencyStore, if set, requires an Idempotency-Key header on
+	// every request, which must be listed in SignedHeaders so that it is
+	// bound to the signature, and rejects requests whose key the store
+	// has already seen for their access ID with ErrIdempotencyKeyReused.
+	// Keys are only recorded once the signature verifies, by each of the
+	// Verify methods; VerifyAll records a request's key once, under the
+	// access ID of its first signature, after all of them verify. See
+	// NewMemoryIdempotencyStore and WithIdempotencyStore.
+	IdempotencyStore IdempotencyStore
+
+	Canonicalizer
+}
+
+// Verify checks a request for validity: all required headers
+// are present and the signature matches, with or without the
+// request method included in the canonical string.
+func (v *Verifier) Verify(r *http.Request) error {
+	_, err := v.VerifyFormat(r)
+	return err
+}
+
+// VerifyFormat checks a request as in Verify, and also reports which
+// canonical string format the signature matched, as an index into
+// Builders. With the default formats, 0 means the request method was
+// included and 1 means it was not.
+func (v *Verifier) VerifyFormat(r *http.Request) (int, error) {
+	if v.RequireV2 {
+		if err := v.VerifyV2(r); err != nil {
+			return -1, err
+		}
+		return 0, nil
+	}
+
+	start := time.Now()
+	id, match, err := v.verify(r)
+	v.report(start, id, match, err)
+	return match, v.annotate(r, err)
+}
+
+// VerifyAndIdentify checks a request as in Verify, and also returns the
+// access ID it was signed under and the time it was signed, for handlers
+// that key idempotency windows or logs on them: the V2 timestamp with
+// RequireV2, and otherwise the Date (or the header named by DateHeader)
+// as ParseDate parses it, the same parse the date checks use. The time is
+// zero if the Date cannot be parsed, which is only accepted when none of
+// MaxPast, MaxFuture, RejectBefore and SameUTCDay are set. Nothing is
+// returned but the error if the request does not verify.
+func (v *Verifier) VerifyAndIdentify(r *http.Request) (accessID string, signed time.Time, err error) {
+	start := time.Now()
+	if v.RequireV2 {
+		sig, err := v.verifyV2(r)
+		v.report(start, sig.AccessID, 0, err)
+		if err != nil {
+			return "", time.Time{}, v.annotate(r, err)
+		}
+		return sig.AccessID, sig.Timestamp, nil
+	}
+
+	id, match, err := v.verify(r)
+	v.report(start, id, match, err)
+	if err != nil {
+		return "", time.Time{}, v.annotate(r, err)
+	}
+
+	signed, _ = ParseDate(v.date(r))
+	return id, signed, nil
+}
+
+// verify checks the request, returning the access ID it was signed with
+// and the index of the format it matched.
+func (v *Verifier) verify(r *http.Request) (id string, match int, err error) {
+	if err := v.checkHeaders(r); err != nil {
+		return "", -1, err
+	}
+
+	auth := v.authorization(r)
+	if auth == "" {
+		return "", -1, ErrMissingAuthorization
+	}
+
+	id, match, err = v.verifyAuthorization(r, auth)
+	if err != nil {
+		return id, match, err
+	}
+
+	return id, match, v.checkIdempotencyKey(r, id)
+}
+
+// checkHeaders makes the checks of the request that precede verifying
+// its signature.
+func (v *Verifier) checkHeaders(r *http.Request) error {
+	if err := checkRequest(r); err != nil {
+		return err
+	}
+
+	if err := v.checkTLS(r); err != nil {
+		return err
+	}
+
+	if !v.methodAllowed(r.Method) {
+		return ErrMethodNotAllowed
+	}
+
+	if v.RejectEmptyBody && v.requiresBody(r.Method) && (r.Body == nil || r.Body == http.NoBody) {
+		return ErrEmptyBodyNotAllowed
+	}
+
+	if err := v.sufficientHeaders(r); err != nil {
+		return err
+	}
+
+	if err := v.requiredContentHeaders(r); err != nil {
+		return err
+	}
+
+	if err := v.checkIdempotencyKeySigned(r); err != nil {
+		return err
+	}
+
+	if !v.contentTypeAllowed(r) {
+		return ErrContentTypeNotAllowed
+	}
+
+	if v.ExpiresHeader != "" {
+		return v.checkExpires(r)
+	}
+
+	return v.checkDate(v.date(r))
+}
+
+// MissingHeaders returns the names of all the headers verifying the
+// request requires but that it lacks, as Canonicalizer.MissingHeaders
+// does, also listing the body headers required by RequireContentMD5.
+func (v *Verifier) MissingHeaders(r *http.Request) []string {
+	return v.missingHeaders(r, v.RequireContentMD5)
+}
+
+// checkTLS checks the TLS version of the request's connection against
+// MinTLSVersion.
+func (v *Verifier) checkTLS(r *http.Request) error {
+	if v.MinTLSVersion == 0 {
+		return nil
+	}
+
+	if r.TLS == nil || r.TLS.Version < v.MinTLSVersion {
+		return ErrWeakTLS
+	}
+	return nil
+}
+
+// requiredContentHeaders checks for the body headers required on every
+// request by RequireContentMD5.
+func (v *Verifier) requiredContentHeaders(r *http.Request) error {
+	if !v.RequireContentMD5 {
+		return nil
+	}
+
+	if !v.OptionalContentType && r.Header.Get("Content-Type") == "" {
+		return ErrMissingContentType
+	}
+
+	integrity := v.integrityHeader()
+	if r.Header.Get(integrity) == "" {
+		return missingHeader(integrity)
+	}
+
+	return nil
+}
+
+// verifyAuthorization checks the signature in an Authorization value
+// against the request.
+func (v *Verifier) verifyAuthorization(r *http.Request, auth string) (id string, match int, err error) {
+	id, sig, err := v.Format.Parse(auth)
+	if err != nil {
+		return "", -1, err
+	}
+
+	match, err = v.verifySignature(r, id, sig)
+	return id, match, err
+}
+
+// verifySignature checks a signature made under the access ID against
+// the request, returning the index of the format it matched.
+func (v *Verifier) verifySignature(r *http.Request, id, sig string) (int, error) {
+	if err := v.checkAccessID(id); err != nil {
+		return -1, err
+	}
+
+	newMACs, err := v.newMACs(id, r)
+	if err != nil {
+		return -1, err
+	}
+
+	if v.RejectSuspiciousSignatures && !v.plausibleMAC(sig, newMACs[0]) {
+		return -1, ErrSuspiciousSignature
+	}
+
+	signed := v.signedRequest(r)
+	match := -1
+builders:
+	for i, builder := range v.builders(r) {
+		canonicalString := builder.CanonicalString(signed)
+		for _, newMAC := range newMACs {
+			if verifyMAC(sig, canonicalString, newMAC, v.Encoding) {
+				match = i
+				break builders
+			}
+		}
+	}
+
+	if match < 0 {
+		return -1, ErrSignatureMismatch
+	}
+
+	if v.AccessID != "" && subtle.ConstantTimeCompare([]byte(id), []byte(v.AccessID)) != 1 {
+		return -1, ErrAccessIDMismatch
+	}
+
+	return match, nil
+}
+
+// VerifySignature checks a request against an already parsed signature,
+// in place of its Authorization header, as Verify or VerifyV2 would
+// check it, depending on the signature's layout.
+func (v *Verifier) VerifySignature(r *http.Request, sig Signature) error {
+	start := time.Now()
+	var err error
+	match := 0
+	if sig.IsV2() {
+		err = v.checkHeadersV2(r)
+		if err == nil {
+			err = v.verifySignatureV2(r, sig)
+		}
+	} else {
+		err = v.checkHeaders(r)
+		if err == nil {
+			match, err = v.verifySignature(r, sig.AccessID, sig.Signature)
+		}
+	}
+	if err == nil {
+		err = v.checkIdempotencyKey(r, sig.AccessID)
+	}
+
+	v.report(start, sig.AccessID, match, err)
+	return v.annotate(r, err)
+}
+
+// VerifyAll checks a request carrying several Authorization headers, as
+// when each proxy in a chain adds its own signature, returning nil only
+// if every one verifies as in Verify. Each signature must be in its own
+// header field; a value joining several with commas is not split. The
+// callbacks are called for each signature. AuthorizationCookie is not
+// used.
+func (v *Verifier) VerifyAll(r *http.Request) error {
+	start := time.Now()
+	if err := v.checkHeaders(r); err != nil {
+		v.report(start, "", -1, err)
+		return v.annotate(r, err)
+	}
+
+	values := r.Header[http.CanonicalHeaderKey(authorizationHeader(v.AuthorizationHeader))]
+	if len(values) == 0 {
+		v.report(start, "", -1, ErrMissingAuthorization)
+		return v.annotate(r, ErrMissingAuthorization)
+	}
+
+	var first string
+	for i, auth := range values {
+		id, match, err := v.verifyAuthorization(r, v.decodeAuthorization(auth))
+		v.report(start, id, match, err)
+		start = time.Now()
+		if err != nil {
+			return v.annotate(r, err)
+		}
+		if i == 0 {
+			first = id
+		}
+	}
+
+	if err := v.checkIdempotencyKey(r, first); err != nil {
+		v.report(start, first, -1, err)
+		return v.annotate(r, err)
+	}
+	return nil
+}
+
+// annotate returns err as a *RequestError if it is an *AuthError and the
+// request carries an ID in RequestIDHeader.
+func (v *Verifier) annotate(r *http.Request, err error) error {
+	authErr, ok := err.(*AuthError)
+	if !ok || v.RequestIDHeader == "" || r == nil {
+		return err
+	}
+
+	if id := r.Header.Get(v.RequestIDHeader); id != "" {
+		return &RequestError{authErr, id}
+	}
+	return err
+}
+
+// report calls the callbacks for the outcome of a check that began at
+// start.
+func (v *Verifier) report(start time.Time, id string, match int, err error) {
+	if v.OnVerifyTiming != nil {
+		v.OnVerifyTiming(time.Since(start), err == nil)
+	}
+
+	switch {
+	case err != nil:
+		if v.OnFailure != nil {
+			v.OnFailure(errorCode(err))
+		}
+	case match > 0:
+		if v.OnLegacyScheme != nil {
+			v.OnLegacyScheme(id)
+		}
+		fallthrough
+	default:
+		if v.OnSuccess != nil {
+			v.OnSuccess(id)
+		}
+	}
+}
+
+// VerifyWithBodyReader checks a request as in Verify, after first reading
+// and buffering its body and checking it against the header named by
+// IntegrityHeader. The body is restored for downstream handlers. At most
+// MaxBodySize bytes are read; larger bodies fail with ErrBodyTooLarge.
+func (v *Verifier) VerifyWithBodyReader(r *http.Request) error {
+	if r != nil && r.Body != nil && r.Body != http.NoBody {
+		if err := v.verifyBody(r); err != nil {
+			return err
+		}
+	}
+
+	return v.Verify(r)
+}
+
+func (v *Verifier) verifyBody(r *http.Request) error {
+	limit := maxBodySize(v.MaxBodySize)
+	if http.CanonicalHeaderKey(v.integrityHeader()) == DigestHeader {
+		return verifyDigest(r, limit)
+	}
+	return verifyContentMD5(r, limit, v.NormalizeContentMD5, v.JSONContentMD5, v.DecodedContentMD5)
+}
+
+func (v *Verifier) authorization(r *http.Request) string {
+	if auth := r.Header.Get(authorizationHeader(v.AuthorizationHeader)); auth != "" {
+		return v.decodeAuthorization(auth)
+	}
+
+	if v.AuthorizationCookie != "" {
+		if cookie, err := r.Cookie(v.AuthorizationCookie); err == nil {
+			return v.decodeAuthorization(cookie.Value)
+		}
+	}
+
+	return ""
+}
+
+// decodeAuthorization percent-decodes an Authorization value if
+// URLDecodeAuthorization is set.
+func (v *Verifier) decodeAuthorization(auth string) string {
+	if !v.URLDecodeAuthorization {
+		return auth
+	}
+
+	decoded, err := url.PathUnescape(auth)
+	if err != nil {
+		return auth
+	}
+	return decoded
+}
+
+func (v *Verifier) builder(r *http.Request) CanonicalBuilder {
+	if b, ok := v.MethodBuilders[strings.ToUpper(r.Method)]; ok {
+		return b
+	}
+
+	if v.Builder != nil {
+		return v.Builder
+	}
+	return v.Canonicalizer
+}
+
+// newMACs returns the functions creating the MACs used to verify a
+// request signed under the access ID, one for each secret it may have
+// been signed with.
+func (v *Verifier) newMACs(id string, r *http.Request) ([]func() hash.Hash, error) {
+	if v.VersionedKeyFunc == nil && v.DatedKeyFunc == nil && v.KeyFunc == nil && v.KeyProvider != nil {
+		return []func() hash.Hash{v.KeyProvider.NewMAC}, nil
+	}
+
+	var signed time.Time
+	if v.VersionedKeyFunc != nil || v.DatedKeyFunc != nil || v.SigningKey != nil {
+		var err error
+		signed, err = ParseDate(v.date(r))
+		if err != nil {
+			return nil, ErrInvalidDate
+		}
+	}
+
+	secrets, err := v.keys(id, signed)
+	if err != nil {
+		return nil, err
+	}
+
+	newMACs := make([]func() hash.Hash, len(secrets))
+	for i, secret := range secrets {
+		newMACs[i] = keyMAC(signingKey(v.SigningKey, secret, signed))
+	}
+	return newMACs, nil
+}
+
+// keys returns the secrets a request signed under the access ID at the
+// given time may have been signed with. Empty secrets are left out, as
+// anyone can sign with one. It returns ErrSignatureMismatch rather than
+// an empty list.
+func (v *Verifier) keys(id string, signed time.Time) ([]string, error) {
+	if v.VersionedKeyFunc == nil {
+		if v.DatedKeyFunc == nil && v.KeyFunc == nil && v.KeyProvider != nil {
+			return nil, errKeyProviderUnsupported
+		}
+
+		secret, err := v.key(id, signed)
+		if err != nil {
+			return nil, err
+		}
+		if secret == "" {
+			return nil, ErrSignatureMismatch
+		}
+		return []string{secret}, nil
+	}
+
+	versions, err := v.VersionedKeyFunc(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets []string
+	for _, version := range versions {
+		if version.Secret != "" && version.validAt(signed) {
+			secrets = append(secrets, version.Secret)
+		}
+	}
+
+	if len(secrets) == 0 {
+		return nil, ErrSignatureMismatch
+	}
+	return secrets, nil
+}
+
+// key returns the secret for a request signed under the access ID at the
+// given time.
+func (v *Verifier) key(id string, signed time.Time) (string, error) {
+	switch {
+	case v.DatedKeyFunc != nil:
+		return v.DatedKeyFunc(id, signed)
+	case v.KeyFunc != nil:
+		return v.KeyFunc(id)
+	}
+	return v.Secret, nil
+}
+
+// signedRequest returns the request as the client signed it, with
+// PathPrefix restored.
+func (v *Verifier) signedRequest(r *http.Request) *http.Request {
+	if v.PathPrefix == "" || r.URL == nil {
+		return r
+	}
+
+	prefix := strings.TrimSuffix(v.PathPrefix, "/")
+	u := *r.URL
+	u.Path = prefix + u.Path
+	if u.RawPath != "" {
+		u.RawPath = prefix + u.RawPath
+	}
+
+	signed := *r
+	signed.URL = &u
+	return &signed
+}
+
+// plausibleMAC reports whether sig decodes to a MAC of the size newMAC
+// produces.
+func (v *Verifier) plausibleMAC(sig string, newMAC func() hash.Hash) bool {
+	mac, err := v.Encoding.Decode(sig)
+	return err == nil && len(mac) == newMAC().Size()
+}
+
+func (v *Verifier) builders(r *http.Request) []CanonicalBuilder {
+	if len(v.Builders) > 0 {
+		return v.Builders
+	}
+
+	builder := v.builder(r)
+	if v.RequireMethodForMutating && v.mutating(r.Method) {
+		return []CanonicalBuilder{WithMethod(builder)}
+	}
+	return []CanonicalBuilder{WithMethod(builder), builder}
+}
+
+// mutating reports whether RequireMethodForMutating applies to the
+// method.
+func (v *Verifier) mutating(method string) bool {
+	methods := v.MutatingMethods
+	if methods == nil {
+		methods = DefaultMutatingMethods
+	}
+
+	for _, m := range methods {
+		if strings.EqualFold(method, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClaimedAccessID returns the access ID claimed by a request, as the
+// package-level AccessID does, but read as the Verifier reads the
+// Authorization value, from AuthorizationHeader or AuthorizationCookie,
+// and checked as ValidateAccessID sets, failing with ErrInvalidAccessID.
+// It is not named AccessID, as that is the Verifier's field. As with
+// AccessID, the returned ID is NOT verified.
+func (v *Verifier) ClaimedAccessID(r *http.Request) (string, error) {
+	if err := checkRequest(r); err != nil {
+		return "", err
+	}
+
+	auth := v.authorization(r)
+	if auth == "" {
+		return "", ErrMissingAuthorization
+	}
+
+	sig, err := ParseSignature(auth)
+	if err != nil {
+		return "", err
+	}
+	if err := v.checkAccessID(sig.AccessID); err != nil {
+		return "", err
+	}
+	return sig.AccessID, nil
+}
+
+// checkAccessID checks an access ID against the AccessIDPattern, when
+// ValidateAccessID is set.
+func (v *Verifier) checkAccessID(id string) error {
+	if !v.ValidateAccessID {
+		return nil
+	}
+
+	pattern := v.AccessIDPattern
+	if pattern == nil {
+		pattern = DefaultAccessIDPattern
+	}
+	if !pattern.MatchString(id) {
+		return ErrInvalidAccessID
+	}
+	return nil
+}
+
+// requiresBody reports whether RejectEmptyBody applies to the method.
+func (v *Verifier) requiresBody(method string) bool {
+	methods := v.BodyMethods
+	if methods == nil {
+		methods = DefaultBodyMethods
+	}
+
+	for _, m := range methods {
+		if strings.EqualFold(method, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Verifier) methodAllowed(method string) bool {
+	if len(v.AllowedMethods) == 0 {
+		return true
+	}
+
+	for _, allowed := range v.AllowedMethods {
+		if strings.EqualFold(method, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *Verifier) contentTypeAllowed(r *http.Request) bool {
+	if len(v.AllowedContentTypes) == 0 || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+
+	mediaType := strings.TrimSpace(strings.SplitN(r.Header.Get("Content-Type"), ";", 2)[0])
+	for _, allowed := range v.AllowedContentTypes {
+		if strings.EqualFold(mediaType, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *Verifier) checkDate(date string) error {
+	if v.MaxPast <= 0 && v.MaxFuture <= 0 && v.RejectBefore.IsZero() && !v.SameUTCDay {
+		return nil
+	}
+
+	signed, err := ParseDate(date)
+	if err != nil {
+		return ErrInvalidDate
+	}
+
+	if v.MaxPast <= 0 && v.MaxFuture <= 0 {
+		return v.checkCutoffs(signed)
+	}
+
+	return v.checkTime(signed, v.MaxPast)
+}
+
+// checkExpires checks that the expiry in the ExpiresHeader has not
+// passed.
+func (v *Verifier) checkExpires(r *http.Request) error {
+	expires, err := parseUnix(r.Header.Get(v.ExpiresHeader))
+	if err != nil {
+		return ErrInvalidDate
+	}
+
+	if v.now().After(expires) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// checkTime checks that a signing time is no more than maxPast before the
+// current time, if maxPast is positive, and no more than MaxFuture after,
+// as well as that it is not before RejectBefore and, with SameUTCDay, on
+// the current day.
+func (v *Verifier) checkTime(signed time.Time, maxPast time.Duration) error {
+	if err := v.checkCutoffs(signed); err != nil {
+		return err
+	}
+
+	now := v.now()
+	if maxPast > 0 && now.Sub(signed) > maxPast {
+		return ErrDateTooOld
+	}
+
+	maxFuture := v.MaxFuture
+	if maxFuture <= 0 {
+		maxFuture = DefaultMaxFuture
+	}
+
+	if signed.After(now.Add(maxFuture)) {
+		return ErrDateInFuture
+	}
+
+	return nil
+}
+
+// checkCutoffs checks a signing time against the bounds that apply
+// whether or not MaxPast and MaxFuture are set: RejectBefore and
+// SameUTCDay.
+func (v *Verifier) checkCutoffs(signed time.Time) error {
+	if signed.Before(v.RejectBefore) {
+		return ErrDateTooOld
+	}
+
+	if v.SameUTCDay {
+		sy, sm, sd := signed.UTC().Date()
+		ny, nm, nd := v.now().UTC().Date()
+		if sy != ny || sm != nm || sd != nd {
+			return ErrDateNotToday
+		}
+	}
+
+	return nil
+}
+
+func (v *Verifier) now() time.Time {
+	if v.Now == nil {
+		return time.Now()
+	}
+	return v.Now()
+}
@@ -0,0 +1,781 @@
+package apiauth
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifier_AllowedMethods(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com/some/path?x=1&b=2", nil)
+	req.Header.Add("Content-Type", "text/plain")
+	req.Header.Add("Content-MD5", "WnNni3tnQAUFZDSkgFRwfQ==")
+	req.Header.Add("Date", "Thu, 19 Mar 2015 19:24:24 GMT")
+	req.Header.Add("Authorization", `APIAuth me:43DQKYwiMx3swEwa3raDq5tPxIo=`)
+
+	v := Verifier{Secret: "secret", AllowedMethods: []string{"get", "POST"}}
+	require.NoError(t, v.Verify(req))
+
+	req.Method = "PUT"
+	require.Equal(t, ErrMethodNotAllowed, v.Verify(req))
+
+	v.AllowedMethods = nil
+	require.EqualError(t, v.Verify(req), "Signature mismatch")
+}
+
+func TestVerifier_AllowedContentTypes(t *testing.T) {
+	v := Verifier{Secret: "secret", AllowedContentTypes: []string{"application/json"}}
+
+	for contentType, want := range map[string]error{
+		"application/json":                                 nil,
+		"Application/JSON":                                 nil,
+		"application/json; charset=utf-8":                  nil,
+		"text/plain":                                       ErrContentTypeNotAllowed,
+		"application/json-patch+json":                      ErrContentTypeNotAllowed,
+		"application/x-www-form-urlencoded; charset=utf-8": ErrContentTypeNotAllowed,
+	} {
+		body := []byte(`{}`)
+		req, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader(body))
+		req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Content-MD5", base64md5(body))
+		require.NoError(t, SignWithMethod(req, "me", "secret"))
+
+		require.Equal(t, want, v.Verify(req), contentType)
+	}
+
+	// Requests without a body are not checked.
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Content-Type", "text/plain")
+	require.NoError(t, SignWithMethod(req, "me", "secret"))
+	require.NoError(t, v.Verify(req))
+}
+
+func TestVerifier_MaxPastMaxFuture(t *testing.T) {
+	signed := time.Date(2015, time.March, 20, 19, 37, 40, 0, time.UTC)
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+
+	var now time.Time
+	v := Verifier{Secret: "secret", MaxPast: 15 * time.Minute, Now: func() time.Time { return now }}
+
+	now = signed.Add(15 * time.Minute)
+	require.NoError(t, v.Verify(req))
+
+	now = signed.Add(15*time.Minute + time.Second)
+	require.Equal(t, ErrDateTooOld, v.Verify(req))
+
+	now = signed.Add(-DefaultMaxFuture)
+	require.NoError(t, v.Verify(req))
+
+	now = signed.Add(-DefaultMaxFuture - time.Second)
+	require.Equal(t, ErrDateInFuture, v.Verify(req))
+
+	v.MaxFuture = time.Minute
+	now = signed.Add(-time.Minute)
+	require.NoError(t, v.Verify(req))
+
+	now = signed.Add(-time.Minute - time.Second)
+	require.Equal(t, ErrDateInFuture, v.Verify(req))
+
+	req.Header.Set("Date", "yesterday")
+	require.Equal(t, ErrInvalidDate, v.Verify(req))
+}
+
+func TestVerifier_SameUTCDay(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 23:59:59 GMT")
+	req.Header.Set("Authorization", "APIAuth me:"+Compute(CanonicalString(req), "secret"))
+
+	signed := time.Date(2015, time.March, 20, 23, 59, 59, 0, time.UTC)
+	v := Verifier{Secret: "secret", SameUTCDay: true}
+
+	v.Now = func() time.Time { return signed }
+	require.NoError(t, v.Verify(req))
+
+	v.Now = func() time.Time { return time.Date(2015, time.March, 20, 0, 0, 0, 0, time.UTC) }
+	require.NoError(t, v.Verify(req))
+
+	v.Now = func() time.Time { return signed.Add(time.Second) }
+	require.Equal(t, ErrDateNotToday, v.Verify(req))
+
+	v.Now = func() time.Time { return time.Date(2015, time.March, 19, 23, 59, 59, 0, time.UTC) }
+	require.Equal(t, ErrDateNotToday, v.Verify(req))
+
+	// Only the UTC day counts, not that of the server's zone.
+	v.Now = func() time.Time { return signed.Add(2 * time.Second).In(time.FixedZone("EST", -5*60*60)) }
+	require.Equal(t, ErrDateNotToday, v.Verify(req))
+
+	// It applies alongside MaxPast.
+	v.MaxPast = time.Hour
+	v.Now = func() time.Time { return signed.Add(30 * time.Minute) }
+	require.Equal(t, ErrDateNotToday, v.Verify(req))
+	v.Now = func() time.Time { return signed.Add(-3 * time.Minute) }
+	require.NoError(t, v.Verify(req))
+
+	v2, _ := http.NewRequest("GET", "http://example.com", nil)
+	s := Signer{AccessID: "me", Secret: "secret"}
+	require.NoError(t, s.SignV2(v2, HMACSHA256))
+	v.Now = nil
+	require.NoError(t, v.VerifyV2(v2))
+	v.Now = func() time.Time { return time.Now().Add(24 * time.Hour) }
+	v.MaxPast = 48 * time.Hour
+	require.Equal(t, ErrDateNotToday, v.VerifyV2(v2))
+}
+
+func TestVerifyAndIdentify(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+
+	id, signed, err := VerifyAndIdentify(req, "secret")
+	require.NoError(t, err)
+	require.Equal(t, "me", id)
+	require.True(t, signed.Equal(time.Date(2015, time.March, 20, 19, 37, 40, 0, time.UTC)))
+
+	id, signed, err = VerifyAndIdentify(req, "wrong")
+	require.Equal(t, ErrSignatureMismatch, err)
+	require.Empty(t, id)
+	require.True(t, signed.IsZero())
+
+	s := Signer{AccessID: "me", Secret: "secret"}
+	v2, _ := http.NewRequest("GET", "http://example.com", nil)
+	require.NoError(t, s.SignV2(v2, HMACSHA256))
+	sig, err := ParseSignature(v2.Header.Get("Authorization"))
+	require.NoError(t, err)
+
+	v := Verifier{Secret: "secret", RequireV2: true}
+	id, signed, err = v.VerifyAndIdentify(v2)
+	require.NoError(t, err)
+	require.Equal(t, "me", id)
+	require.Equal(t, sig.Timestamp, signed)
+}
+
+func TestVerifier_MinTLSVersion(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+
+	v := Verifier{Secret: "secret"}
+	require.NoError(t, v.Verify(req))
+
+	v.MinTLSVersion = tls.VersionTLS12
+	require.Equal(t, ErrWeakTLS, v.Verify(req))
+
+	req.TLS = &tls.ConnectionState{Version: tls.VersionTLS11}
+	require.Equal(t, ErrWeakTLS, v.Verify(req))
+	require.Equal(t, http.StatusForbidden, StatusCode(v.Verify(req)))
+
+	req.TLS = &tls.ConnectionState{Version: tls.VersionTLS13}
+	require.NoError(t, v.Verify(req))
+
+	v2, _ := http.NewRequest("GET", "https://example.com", nil)
+	require.NoError(t, SignV2(v2, "me", "secret"))
+	require.Equal(t, ErrWeakTLS, v.VerifyV2(v2))
+
+	v2.TLS = &tls.ConnectionState{Version: tls.VersionTLS13}
+	require.NoError(t, v.VerifyV2(v2))
+}
+
+func TestVerifier_ExpiresHeader(t *testing.T) {
+	c := Canonicalizer{ExpiresHeader: "X-Expires"}
+	s := Signer{AccessID: "me", Secret: "secret", Canonicalizer: c}
+
+	req, _ := http.NewRequest("GET", "http://example.com/download?file=1", nil)
+	require.Equal(t, missingHeader("X-Expires"), s.Sign(req))
+
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("X-Expires", "1426880260")
+	require.Equal(t, ",,/download?file=1,1426880260", c.CanonicalString(req))
+	require.Equal(t, []CanonicalComponent{{"ContentType", ""}, {"ContentMD5", ""}, {"URI", "/download?file=1"}, {"Expires", "1426880260"}}, c.CanonicalComponents(req))
+	require.NoError(t, s.Sign(req))
+
+	expires := time.Unix(1426880260, 0)
+	v := Verifier{Secret: "secret", Canonicalizer: c, MaxPast: time.Minute}
+
+	v.Now = func() time.Time { return expires.Add(-time.Hour) }
+	require.NoError(t, v.Verify(req))
+
+	v.Now = func() time.Time { return expires }
+	require.NoError(t, v.Verify(req))
+
+	// The Date is neither signed nor checked.
+	req.Header.Del("Date")
+	require.NoError(t, v.Verify(req))
+
+	v.Now = func() time.Time { return expires.Add(time.Second) }
+	require.Equal(t, ErrExpired, v.Verify(req))
+
+	// Extending the expiry breaks the signature.
+	v.Now = func() time.Time { return expires }
+	req.Header.Set("X-Expires", "1426890260")
+	require.Equal(t, ErrSignatureMismatch, v.Verify(req))
+
+	req.Header.Set("X-Expires", "tomorrow")
+	require.Equal(t, ErrInvalidDate, v.Verify(req))
+
+	req.Header.Del("X-Expires")
+	require.Equal(t, missingHeader("X-Expires"), v.Verify(req))
+}
+
+func TestVerifier_RejectEmptyBody(t *testing.T) {
+	v := Verifier{Secret: "secret", RejectEmptyBody: true}
+
+	empty, _ := http.NewRequest("POST", "http://example.com/items", nil)
+	empty.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.NoError(t, SignWithMethod(empty, "me", "secret"))
+	require.NoError(t, Verify(empty, "secret"))
+	require.Equal(t, ErrEmptyBodyNotAllowed, v.Verify(empty))
+	require.Equal(t, http.StatusBadRequest, StatusCode(v.Verify(empty)))
+
+	empty.Body = http.NoBody
+	require.Equal(t, ErrEmptyBodyNotAllowed, v.Verify(empty))
+
+	body := []byte(`post body`)
+	full, _ := http.NewRequest("POST", "http://example.com/items", bytes.NewReader(body))
+	full.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	full.Header.Set("Content-Type", "text/plain")
+	full.Header.Set("Content-MD5", base64md5(body))
+	require.NoError(t, SignWithMethod(full, "me", "secret"))
+	require.NoError(t, v.Verify(full))
+
+	get, _ := http.NewRequest("GET", "http://example.com/items", nil)
+	get.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.NoError(t, SignWithMethod(get, "me", "secret"))
+	require.NoError(t, v.Verify(get))
+
+	v.BodyMethods = []string{"get"}
+	require.Equal(t, ErrEmptyBodyNotAllowed, v.Verify(get))
+	require.NoError(t, v.Verify(empty))
+
+	v2, _ := http.NewRequest("GET", "http://example.com/items", nil)
+	require.NoError(t, SignV2(v2, "me", "secret"))
+	require.Equal(t, ErrEmptyBodyNotAllowed, v.VerifyV2(v2))
+}
+
+func TestVerifier_RequireMethodForMutating(t *testing.T) {
+	var legacy []string
+	v := Verifier{
+		Secret:                   "secret",
+		RequireMethodForMutating: true,
+		MutatingMethods:          []string{"delete"},
+		OnLegacyScheme:           func(id string) { legacy = append(legacy, id) },
+	}
+
+	newRequest := func(method string) *http.Request {
+		req, _ := http.NewRequest(method, "http://example.com/items/1", nil)
+		req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+		require.NoError(t, Sign(req, "me", "secret"))
+		return req
+	}
+
+	require.NoError(t, v.Verify(newRequest("POST")))
+	require.Equal(t, ErrSignatureMismatch, v.Verify(newRequest("DELETE")))
+	require.Equal(t, []string{"me"}, legacy)
+
+	del, _ := http.NewRequest("DELETE", "http://example.com/items/1", nil)
+	del.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.NoError(t, SignWithMethod(del, "me", "secret"))
+	match, err := v.VerifyFormat(del)
+	require.NoError(t, err)
+	require.Equal(t, 0, match)
+
+	// Builders take precedence.
+	v.Builders = []CanonicalBuilder{Canonicalizer{}}
+	require.NoError(t, v.Verify(newRequest("DELETE")))
+}
+
+func TestVerifier_ValidateAccessID(t *testing.T) {
+	v := Verifier{Secret: "secret", ValidateAccessID: true}
+	keyFunc := func(id string) (string, error) {
+		t.Fatalf("secret looked up for %q", id)
+		return "", nil
+	}
+
+	newRequest := func(id string) *http.Request {
+		req, _ := http.NewRequest("GET", "http://example.com/items", nil)
+		req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+		require.NoError(t, Sign(req, id, "secret"))
+		return req
+	}
+
+	for _, id := range []string{"me", "ME-2", "svc_account", "vTz3-_0"} {
+		require.NoError(t, v.Verify(newRequest(id)), id)
+	}
+
+	for _, id := range []string{"me|admin", "me\tadmin", "me admin", "me\n", "mé", "me.admin", "me/.."} {
+		req := newRequest(id)
+		require.NoError(t, Verify(req, "secret"), id)
+		require.Equal(t, ErrInvalidAccessID, v.Verify(req), id)
+
+		strict := Verifier{KeyFunc: keyFunc, ValidateAccessID: true}
+		require.Equal(t, ErrInvalidAccessID, strict.Verify(req), id)
+	}
+	require.Equal(t, http.StatusBadRequest, StatusCode(v.Verify(newRequest("me|admin"))))
+
+	v2, _ := http.NewRequest("GET", "http://example.com/items", nil)
+	require.NoError(t, SignV2(v2, "me|admin", "secret"))
+	require.Equal(t, ErrInvalidAccessID, v.VerifyV2(v2))
+
+	v.AccessIDPattern = regexp.MustCompile(`^[a-z]+\.[a-z]+$`)
+	require.NoError(t, v.Verify(newRequest("me.admin")))
+	require.Equal(t, ErrInvalidAccessID, v.Verify(newRequest("me")))
+}
+
+func TestVerifier_ClaimedAccessID(t *testing.T) {
+	v := Verifier{ValidateAccessID: true, AuthorizationHeader: "X-APIAuth-Authorization"}
+
+	req, _ := http.NewRequest("GET", "http://example.com/items", nil)
+	_, err := v.ClaimedAccessID(req)
+	require.Equal(t, ErrMissingAuthorization, err)
+
+	req.Header.Set("X-APIAuth-Authorization", "APIAuth web-client_1:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	id, err := v.ClaimedAccessID(req)
+	require.NoError(t, err)
+	require.Equal(t, "web-client_1", id)
+
+	req.Header.Set("X-APIAuth-Authorization", "APIAuth me|admin:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	id, err = v.ClaimedAccessID(req)
+	require.Equal(t, ErrInvalidAccessID, err)
+	require.Equal(t, "", id)
+
+	v.AccessIDPattern = regexp.MustCompile(`^[a-z|]+$`)
+	id, err = v.ClaimedAccessID(req)
+	require.NoError(t, err)
+	require.Equal(t, "me|admin", id)
+
+	_, err = v.ClaimedAccessID(nil)
+	require.Equal(t, ErrNilRequest, err)
+}
+
+func TestVerifier_URLDecodeAuthorization(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://example.com", bytes.NewReader([]byte(`post body`)))
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-MD5", "WnNni3tnQAUFZDSkgFRwfQ==")
+	req.Header.Set("Authorization", "APIAuth%20me:%2FZ%2FMqEW%2Bv23Cm3w3Ra2mMGH9KFw%3D")
+
+	v := Verifier{Secret: "secret"}
+	require.Error(t, v.Verify(req))
+
+	v.URLDecodeAuthorization = true
+	require.NoError(t, v.Verify(req))
+
+	// Values that are not encoded, or only partly, still verify, and a
+	// plus is not decoded as a space.
+	req.Header.Set("Authorization", "APIAuth me:/Z/MqEW+v23Cm3w3Ra2mMGH9KFw=")
+	require.NoError(t, v.Verify(req))
+	req.Header.Set("Authorization", "APIAuth me:%2FZ/MqEW+v23Cm3w3Ra2mMGH9KFw=")
+	require.NoError(t, v.Verify(req))
+
+	req.Header.Set("Authorization", "APIAuth me:%zz")
+	require.Equal(t, ErrSignatureMismatch, v.Verify(req))
+}
+
+func TestVerifier_RejectBefore(t *testing.T) {
+	signed := time.Date(2015, time.March, 20, 19, 37, 40, 0, time.UTC)
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+
+	v := Verifier{Secret: "secret", RejectBefore: signed}
+	require.NoError(t, v.Verify(req))
+
+	v.RejectBefore = signed.Add(time.Second)
+	require.Equal(t, ErrDateTooOld, v.Verify(req))
+
+	// Without MaxPast or MaxFuture, dates in the future are accepted.
+	v.RejectBefore = signed.Add(-time.Hour)
+	v.Now = func() time.Time { return signed.Add(-time.Hour) }
+	require.NoError(t, v.Verify(req))
+
+	// With them, both bounds apply.
+	v.MaxPast = 24 * time.Hour
+	v.Now = func() time.Time { return signed.Add(time.Hour) }
+	require.NoError(t, v.Verify(req))
+
+	v.RejectBefore = signed.Add(time.Minute)
+	require.Equal(t, ErrDateTooOld, v.Verify(req))
+
+	req.Header.Set("Date", "yesterday")
+	require.Equal(t, ErrInvalidDate, v.Verify(req))
+}
+
+func TestParseDate(t *testing.T) {
+	want := time.Date(2015, time.March, 20, 19, 37, 40, 0, time.UTC)
+
+	for _, date := range []string{
+		"Fri, 20 Mar 2015 19:37:40 GMT",
+		"Fri, 20 Mar 2015 19:37:40 +0000",
+		"Friday, 20-Mar-15 19:37:40 GMT",
+	} {
+		got, err := ParseDate(date)
+		require.NoError(t, err, date)
+		require.True(t, want.Equal(got), date)
+	}
+
+	_, err := ParseDate("yesterday")
+	require.Error(t, err)
+}
+
+func TestParseDate_FractionalSeconds(t *testing.T) {
+	want := time.Date(2015, time.March, 20, 19, 37, 40, 123e6, time.UTC)
+
+	for _, date := range []string{
+		"Fri, 20 Mar 2015 19:37:40.123 GMT",
+		"Fri, 20 Mar 2015 19:37:40.123 +0000",
+	} {
+		got, err := ParseDate(date)
+		require.NoError(t, err, date)
+		require.True(t, want.Equal(got), date)
+	}
+}
+
+func TestVerifier_MaxPastFractionalSeconds(t *testing.T) {
+	signed := time.Date(2015, time.March, 20, 19, 37, 40, 123e6, time.UTC)
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40.123 GMT")
+	require.NoError(t, Sign(req, "me", "secret"))
+
+	var now time.Time
+	v := Verifier{Secret: "secret", MaxPast: 15 * time.Minute, Now: func() time.Time { return now }}
+
+	now = signed.Add(15 * time.Minute)
+	require.NoError(t, v.Verify(req))
+
+	now = signed.Add(15*time.Minute + time.Millisecond)
+	require.Equal(t, ErrDateTooOld, v.Verify(req))
+}
+
+func TestVerifyWithBodyReader(t *testing.T) {
+	body := []byte(`post body`)
+	newRequest := func() *http.Request {
+		// A reader of unknown length, so the request would be chunked.
+		req, _ := http.NewRequest("POST", "http://example.com", ioutil.NopCloser(bytes.NewReader(body)))
+		req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Content-MD5", base64md5(body))
+		return req
+	}
+
+	req := newRequest()
+	require.Equal(t, int64(0), req.ContentLength)
+	require.NoError(t, Sign(req, "me", "secret"))
+	require.NoError(t, VerifyWithBodyReader(req, "secret"))
+
+	read, err := ioutil.ReadAll(req.Body)
+	require.NoError(t, err)
+	require.Equal(t, body, read)
+
+	req = newRequest()
+	req.Header.Set("Content-MD5", "WnNni3tnQAUFZDSkgFRwfQ==")
+	require.NoError(t, Sign(req, "me", "secret"))
+	require.NoError(t, Verify(req, "secret"))
+	require.Equal(t, ErrContentMD5Mismatch, VerifyWithBodyReader(req, "secret"))
+
+	req = newRequest()
+	require.NoError(t, Sign(req, "me", "secret"))
+	v := Verifier{Secret: "secret", MaxBodySize: 4}
+	require.Equal(t, ErrBodyTooLarge, v.VerifyWithBodyReader(req))
+
+	req = newRequest()
+	req.Body = ioutil.NopCloser(errReader{errors.New("connection reset")})
+	require.EqualError(t, VerifyWithBodyReader(req, "secret"), "connection reset")
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestVerifier_Callbacks(t *testing.T) {
+	var successes, failures, legacies []string
+	v := Verifier{
+		Secret:         "secret",
+		OnSuccess:      func(id string) { successes = append(successes, id) },
+		OnFailure:      func(reason string) { failures = append(failures, reason) },
+		OnLegacyScheme: func(id string) { legacies = append(legacies, id) },
+	}
+
+	req, _ := http.NewRequest("POST", "http://example.com/some/path?x=1&b=2", nil)
+	req.Header.Add("Content-Type", "text/plain")
+	req.Header.Add("Content-MD5", "WnNni3tnQAUFZDSkgFRwfQ==")
+	req.Header.Add("Date", "Thu, 19 Mar 2015 19:24:24 GMT")
+
+	require.NoError(t, SignWithMethod(req, "modern", "secret"))
+	require.NoError(t, v.Verify(req))
+
+	req.Header.Del("Authorization")
+	require.NoError(t, Sign(req, "legacy", "secret"))
+	require.NoError(t, v.Verify(req))
+
+	req.Header.Set("Authorization", "garbage")
+	require.Error(t, v.Verify(req))
+
+	req.Header.Del("Date")
+	require.Error(t, v.Verify(req))
+
+	require.Equal(t, []string{"modern", "legacy"}, successes)
+	require.Equal(t, []string{"legacy"}, legacies)
+	require.Equal(t, []string{"malformed_authorization", "missing_date"}, failures)
+}
+
+func TestVerifier_OnVerifyTiming(t *testing.T) {
+	var outcomes []bool
+	var durations []time.Duration
+	v := Verifier{
+		Secret: "secret",
+		OnVerifyTiming: func(d time.Duration, ok bool) {
+			durations = append(durations, d)
+			outcomes = append(outcomes, ok)
+		},
+	}
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.Header.Set("Authorization", "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	require.NoError(t, v.Verify(req))
+
+	req.Header.Set("Authorization", "APIAuth me:AAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+	require.Equal(t, ErrSignatureMismatch, v.Verify(req))
+
+	v2, _ := http.NewRequest("GET", "http://example.com", nil)
+	require.NoError(t, SignV2(v2, "me", "secret"))
+	require.NoError(t, v.VerifyV2(v2))
+
+	require.Equal(t, []bool{true, false, true}, outcomes)
+	for _, d := range durations {
+		require.True(t, d >= 0)
+	}
+
+	// Without the callback, nothing is reported.
+	v.OnVerifyTiming = nil
+	require.NoError(t, v.VerifyV2(v2))
+	require.Len(t, outcomes, 3)
+}
+
+func TestVerifier_Builders(t *testing.T) {
+	current := Canonicalizer{IncludeHost: true}
+	previous := Canonicalizer{}
+
+	req, _ := http.NewRequest("GET", "http://example.com/some/path", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+
+	var legacies []string
+	v := Verifier{
+		Secret:         "secret",
+		Builders:       []CanonicalBuilder{WithMethod(current), previous},
+		OnLegacyScheme: func(id string) { legacies = append(legacies, id) },
+	}
+
+	s := Signer{AccessID: "new", Secret: "secret", WithMethod: true, Canonicalizer: current}
+	require.NoError(t, s.Sign(req))
+	match, err := v.VerifyFormat(req)
+	require.NoError(t, err)
+	require.Equal(t, 0, match)
+
+	req.Header.Del("Authorization")
+	require.NoError(t, Sign(req, "old", "secret"))
+	match, err = v.VerifyFormat(req)
+	require.NoError(t, err)
+	require.Equal(t, 1, match)
+	require.Equal(t, []string{"old"}, legacies)
+
+	req.Header.Del("Authorization")
+	require.NoError(t, SignWithMethod(req, "other", "secret"))
+	match, err = v.VerifyFormat(req)
+	require.Equal(t, ErrSignatureMismatch, err)
+	require.Equal(t, -1, match)
+
+	// The default formats are the method-included one, then the legacy one.
+	match, err = (&Verifier{Secret: "secret"}).VerifyFormat(req)
+	require.NoError(t, err)
+	require.Equal(t, 0, match)
+}
+
+func TestVerifier_AuthorizationCookie(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.AddCookie(&http.Cookie{Name: "X-APIAuth", Value: "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY="})
+
+	require.Equal(t, ErrMissingAuthorization, Verify(req, "secret"))
+
+	v := Verifier{Secret: "secret", AuthorizationCookie: "X-APIAuth"}
+	require.NoError(t, v.Verify(req))
+
+	// The header takes precedence.
+	req.Header.Set("Authorization", "APIAuth me:43DQKYwiMx3swEwa3raDq5tPxIo=")
+	require.Equal(t, ErrSignatureMismatch, v.Verify(req))
+
+	req, _ = http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	req.AddCookie(&http.Cookie{Name: "other", Value: "APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY="})
+	require.Equal(t, ErrMissingAuthorization, v.Verify(req))
+}
+
+func TestVerifyAll(t *testing.T) {
+	keys := map[string]string{"client": "secret", "proxy": "proxy secret"}
+	keyFunc := func(id string) (string, error) {
+		if key, ok := keys[id]; ok {
+			return key, nil
+		}
+		return "", errUnknownKey
+	}
+
+	req, _ := http.NewRequest("GET", "http://example.com/some/path", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.Equal(t, ErrMissingAuthorization, VerifyAll(req, keyFunc))
+
+	// Each hop adds its own header field, signing the same canonical
+	// string.
+	canonical := CanonicalStringWithMethod(req)
+	req.Header.Add("Authorization", "APIAuth client:"+Compute(canonical, "secret"))
+	req.Header.Add("Authorization", "APIAuth proxy:"+Compute(canonical, "proxy secret"))
+	require.Len(t, req.Header["Authorization"], 2)
+	require.NoError(t, VerifyAll(req, keyFunc))
+
+	var verified []string
+	v := Verifier{KeyFunc: keyFunc, OnSuccess: func(id string) { verified = append(verified, id) }}
+	require.NoError(t, v.VerifyAll(req))
+	require.Equal(t, []string{"client", "proxy"}, verified)
+
+	// Any one failing fails the request.
+	keys["proxy"] = "other"
+	require.Equal(t, ErrSignatureMismatch, VerifyAll(req, keyFunc))
+
+	req.Header.Add("Authorization", "APIAuth stranger:"+Compute(canonical, "secret"))
+	keys["proxy"] = "proxy secret"
+	require.Equal(t, errUnknownKey, VerifyAll(req, keyFunc))
+}
+
+func TestVerifier_RejectSuspiciousSignatures(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+
+	v := Verifier{Secret: "secret", RejectSuspiciousSignatures: true}
+	for auth, want := range map[string]error{
+		"APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY=": nil,
+		"APIAuth me:AAAAAAAAAAAAAAAAAAAAAAAAAAA=": ErrSignatureMismatch,
+		"APIAuth me:me":                           ErrSuspiciousSignature,
+		"APIAuth me:N7N1BXAWv6+RXos4vSAAd7D0XJY":  ErrSuspiciousSignature,
+		"APIAuth me:" + base64md5([]byte("body")): ErrSuspiciousSignature,
+	} {
+		req.Header.Set("Authorization", auth)
+		require.Equal(t, want, v.Verify(req), auth)
+
+		if want == ErrSuspiciousSignature {
+			require.Equal(t, ErrSignatureMismatch, Verify(req, "secret"), auth)
+		}
+	}
+
+	// The expected length follows the algorithm.
+	req, _ = http.NewRequest("GET", "http://example.com", nil)
+	require.NoError(t, SignV2(req, "me", "secret"))
+	v.KeyFunc = func(string) (string, error) { return "secret", nil }
+	require.NoError(t, v.VerifyV2(req))
+
+	req.Header.Set("Authorization", "APIAuth-HMAC-SHA256 me:1426880260:N7N1BXAWv6+RXos4vSAAd7D0XJY=")
+	v.Now = func() time.Time { return time.Unix(1426880260, 0) }
+	require.Equal(t, ErrSuspiciousSignature, v.VerifyV2(req))
+}
+
+func TestVerifier_PathPrefix(t *testing.T) {
+	client, _ := http.NewRequest("GET", "http://gateway.example.com/api/users?page=2", nil)
+	client.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.NoError(t, SignWithMethod(client, "me", "secret"))
+
+	backend, _ := http.NewRequest("GET", "http://backend.example.com/users?page=2", nil)
+	backend.Header = client.Header
+	require.Equal(t, ErrSignatureMismatch, Verify(backend, "secret"))
+
+	for _, prefix := range []string{"/api", "/api/"} {
+		v := Verifier{Secret: "secret", PathPrefix: prefix}
+		require.NoError(t, v.Verify(backend), prefix)
+		require.Equal(t, "/users", backend.URL.Path)
+	}
+
+	v := Verifier{Secret: "secret", PathPrefix: "/v1"}
+	require.Equal(t, ErrSignatureMismatch, v.Verify(backend))
+
+	// Escaping in the path is preserved.
+	client, _ = http.NewRequest("GET", "http://gateway.example.com/api/a%2Fb", nil)
+	client.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.NoError(t, SignV2(client, "me", "secret"))
+
+	backend, _ = http.NewRequest("GET", "http://backend.example.com/a%2Fb", nil)
+	backend.Header = client.Header
+	v = Verifier{Secret: "secret", PathPrefix: "/api"}
+	require.NoError(t, v.VerifyV2(backend))
+}
+
+func TestVerifier_RequireContentMD5(t *testing.T) {
+	v := Verifier{Secret: "secret", RequireContentMD5: true}
+
+	r, _ := http.NewRequest("GET", "http://example.com/", nil)
+	r.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	require.NoError(t, SignWithMethod(r, "me", "secret"))
+	require.NoError(t, Verify(r, "secret"))
+	require.Equal(t, ErrMissingContentType, v.Verify(r))
+
+	r.Header.Set("Content-Type", "application/json")
+	require.Equal(t, ErrMissingContentMD5, v.Verify(r))
+
+	r, _ = http.NewRequest("GET", "http://example.com/", nil)
+	r.Header.Set("Date", "Fri, 20 Mar 2015 19:37:40 GMT")
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-MD5", contentMD5(nil))
+	require.NoError(t, SignWithMethod(r, "me", "secret"))
+	require.NoError(t, v.Verify(r))
+
+	r, _ = http.NewRequest("GET", "http://example.com/", nil)
+	r.Header.Set("Content-Type", "application/json")
+	require.NoError(t, SignV2(r, "me", "secret"))
+	require.Equal(t, ErrMissingContentMD5, v.VerifyV2(r))
+}
+
+func TestCanonicalizer_DateHeader(t *testing.T) {
+	c := Canonicalizer{DateHeader: "X-Client-Date"}
+	now := time.Date(2015, time.March, 20, 19, 37, 40, 0, time.UTC)
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("X-Client-Date", DateForTime(now))
+	req.Header.Set("Date", "Fri, 01 Jan 2010 00:00:00 GMT")
+
+	s := Signer{AccessID: "me", Secret: "secret", WithMethod: true, Canonicalizer: c}
+	require.NoError(t, s.Sign(req))
+	require.Equal(t, "GET,,,/,Fri, 20 Mar 2015 19:37:40 GMT", c.CanonicalStringWithMethod(req))
+
+	// The stale Date is neither signed nor checked.
+	v := Verifier{Secret: "secret", MaxPast: time.Minute, Now: func() time.Time { return now }, Canonicalizer: c}
+	require.NoError(t, v.Verify(req))
+	req.Header.Set("Date", "Sat, 21 Mar 2015 00:00:00 GMT")
+	require.NoError(t, v.Verify(req))
+	req.Header.Del("Date")
+	require.NoError(t, v.Verify(req))
+
+	v.Now = func() time.Time { return now.Add(time.Hour) }
+	require.Equal(t, ErrDateTooOld, v.Verify(req))
+
+	req.Header.Del("X-Client-Date")
+	req.Header.Set("Date", DateForTime(now))
+	err := v.Verify(req)
+	require.Equal(t, "missing_header", err.(*AuthError).Code)
+
+	// V2 signatures sign the timestamp in its place when absent.
+	req, _ = http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Date", "Fri, 01 Jan 2010 00:00:00 GMT")
+	require.NoError(t, s.SignV2(req, HMACSHA256))
+	v.Now = nil
+	require.NoError(t, v.VerifyV2(req))
+}